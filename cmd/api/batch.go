@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// The batchRequest struct is one of the requests in a batch. The body is any JSON value, and is sent as the request body.
+type batchRequest struct {
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// The errBatchFailed error rolls back the transaction for an atomic batch when one of its requests fails.
+var errBatchFailed = errors.New("batch request failed")
+
+// The batchResult struct is the response to one of the requests in a batch. JSON response bodies are included as they are, and
+// anything else as a string.
+type batchResult struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    interface{}       `json:"body,omitempty"`
+}
+
+// The batchResponseWriter type is the http.ResponseWriter a request in a batch is handled with. It keeps the response in memory,
+// so that it can be added to the batch's results. If the handler fails with a server error, serverErrorResponse() records the
+// error in err, so that an atomic batch can tell whether it's worth retrying.
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+	err    error
+}
+
+func (bw *batchResponseWriter) Header() http.Header {
+	return bw.header
+}
+
+func (bw *batchResponseWriter) WriteHeader(status int) {
+	if bw.status == 0 {
+		bw.status = status
+	}
+}
+
+func (bw *batchResponseWriter) Write(b []byte) (int, error) {
+	bw.WriteHeader(http.StatusOK)
+	return bw.body.Write(b)
+}
+
+// The result() method turns the response into a batchResult.
+func (bw *batchResponseWriter) result() batchResult {
+	result := batchResult{Status: bw.status, Headers: map[string]string{}}
+
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+
+	for key := range bw.header {
+		result.Headers[key] = bw.header.Get(key)
+	}
+
+	if bw.body.Len() > 0 {
+		if strings.HasPrefix(bw.header.Get("Content-Type"), "application/json") && json.Valid(bw.body.Bytes()) {
+			result.Body = json.RawMessage(bw.body.Bytes())
+		} else {
+			result.Body = bw.body.String()
+		}
+	}
+
+	return result
+}
+
+// The batchHandler runs a list of requests, one after another, and responds with the result of each of them, so that a
+// client can make lots of small changes in one round trip. Each request goes through the same router, middleware and handler
+// as it would on its own, as the user making the batch request.
+//
+// Normally the requests are independent, and one failing doesn't stop the rest. In an atomic batch, the changes are all made in
+// one database transaction: the batch stops at the first request that fails, and everything is rolled back. Because of that,
+// an atomic batch can't contain requests that change anything outside the database, like poster uploads.
+func (app *application) batchHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Atomic   bool           `json:"atomic"`
+		Requests []batchRequest `json:"requests"`
+	}
+
+	err := app.readJSONWithLimit(w, r, &input, app.config.batch.maxBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.payloadTooLargeResponse(w, r, maxBytesError.Limit)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Requests) > 0, "requests", "must contain at least one request")
+	v.Check(len(input.Requests) <= app.config.batch.maxRequests, "requests", fmt.Sprintf("must not contain more than %d requests", app.config.batch.maxRequests))
+
+	for i, request := range input.Requests {
+		key := fmt.Sprintf("requests[%d]", i)
+
+		v.Check(validator.In(request.Method, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete), key, "must have a method of GET, POST, PUT, PATCH or DELETE")
+		v.Check(strings.HasPrefix(request.Path, "/v1/"), key, "must have a path starting with /v1/")
+		v.Check(!strings.HasPrefix(request.Path, "/v1/batch"), key, "must not be a batch request")
+		v.Check(!batchStreams(request.Path), key, "can't be part of a batch, since its response is streamed")
+
+		if input.Atomic {
+			v.Check(request.Method == http.MethodGet || atomicBatchWrite(request.Path), key, "can't be part of an atomic batch, since it changes files as well as the database")
+		}
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The requests are dispatched through a router of their own. For an atomic batch, it belongs to a copy of the application
+	// whose models work inside the batch's transaction. If the transaction has to be retried, the whole batch is run again.
+	batch := *app
+
+	if input.Atomic {
+		var results []batchResult
+
+		err := app.models.WithTx(r.Context(), func(tx data.Models) error {
+			batch.models = tx
+
+			var ok bool
+			var cause error
+
+			// The request that failed may have done so because of a serialization failure or deadlock, so we return the error
+			// it failed with as well, which lets WithTx() see that and run the batch again.
+			results, ok, cause = batch.runBatch(r, input.Requests, true)
+			if !ok {
+				return errors.Join(errBatchFailed, cause)
+			}
+
+			return nil
+		})
+		if err != nil && !errors.Is(err, errBatchFailed) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusOK, envelope{"results": results, "committed": err == nil}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	results, _, _ := batch.runBatch(r, input.Requests, false)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"results": results}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The runBatch() method runs the requests in a batch in order, and reports whether they all succeeded. If stopOnFailure is set,
+// the requests after the first one to fail aren't run, and get a 424 Failed Dependency result instead. It also returns the
+// error behind the first server error, if there was one.
+func (app *application) runBatch(r *http.Request, requests []batchRequest, stopOnFailure bool) ([]batchResult, bool, error) {
+	router := app.routes()
+
+	results := make([]batchResult, len(requests))
+	ok := true
+
+	var cause error
+
+	for i, request := range requests {
+		if !ok && stopOnFailure {
+			results[i] = batchResult{
+				Status: http.StatusFailedDependency,
+				Body:   envelope{"error": "not run, because an earlier request in the batch failed"},
+			}
+			continue
+		}
+
+		sub, err := http.NewRequestWithContext(r.Context(), request.Method, request.Path, bytes.NewReader(request.Body))
+		if err != nil {
+			results[i] = batchResult{Status: http.StatusBadRequest, Body: envelope{"error": err.Error()}}
+			ok = false
+			continue
+		}
+
+		if len(request.Body) > 0 {
+			sub.Header.Set("Content-Type", "application/json")
+		}
+
+		for key, value := range request.Headers {
+			sub.Header.Set(key, value)
+		}
+
+		// Every request in the batch is made as the user who made the batch request.
+		sub.Header.Del("Authorization")
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			sub.Header.Set("Authorization", authorization)
+		}
+
+		sub.RemoteAddr = r.RemoteAddr
+
+		bw := &batchResponseWriter{header: make(http.Header)}
+		router.ServeHTTP(bw, sub)
+
+		results[i] = bw.result()
+
+		if results[i].Status >= 400 {
+			ok = false
+		}
+
+		if cause == nil {
+			cause = bw.err
+		}
+	}
+
+	return results, ok, cause
+}
+
+// The atomicBatchWrite() function reports whether a request that isn't a GET can be part of an atomic batch. Poster uploads
+// and deletes can't, since the files they write and delete in the blob store wouldn't be rolled back with the transaction.
+func atomicBatchWrite(path string) bool {
+	path, _, _ = strings.Cut(path, "?")
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	return !(len(segments) == 4 && segments[1] == "movies" && segments[3] == "poster")
+}
+
+// The batchStreams() function reports whether a request is for one of the endpoints that stream their responses: the movie
+// export, the event stream and blob downloads. Their responses can be any size, or never end, so they can't be collected
+// in memory like the other responses in a batch.
+func batchStreams(p string) bool {
+	p, _, _ = strings.Cut(p, "?")
+	p = path.Clean(p)
+
+	return p == "/v1/movies/export" || p == "/v1/movies/events" || strings.HasPrefix(p, "/v1/blobs/")
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+// The deadlockDriver is a database/sql driver whose queries all fail with a PostgreSQL deadlock, apart from the savepoint
+// statements that the models run around their work. It counts the transactions begun on it.
+type deadlockDriver struct {
+	begins atomic.Int32
+}
+
+func (d *deadlockDriver) Open(name string) (driver.Conn, error) {
+	return &deadlockConn{driver: d}, nil
+}
+
+type deadlockConn struct {
+	driver *deadlockDriver
+}
+
+func (c *deadlockConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements aren't supported")
+}
+
+func (c *deadlockConn) Close() error {
+	return nil
+}
+
+func (c *deadlockConn) Begin() (driver.Tx, error) {
+	c.driver.begins.Add(1)
+	return deadlockTx{}, nil
+}
+
+func (c *deadlockConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	for _, prefix := range []string{"SAVEPOINT", "RELEASE", "ROLLBACK"} {
+		if strings.HasPrefix(query, prefix) {
+			return driver.RowsAffected(0), nil
+		}
+	}
+
+	return nil, &pq.Error{Code: "40P01", Message: "deadlock detected"}
+}
+
+func (c *deadlockConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return nil, &pq.Error{Code: "40P01", Message: "deadlock detected"}
+}
+
+type deadlockTx struct{}
+
+func (deadlockTx) Commit() error   { return nil }
+func (deadlockTx) Rollback() error { return nil }
+
+func TestAtomicBatchRetriesDeadlocks(t *testing.T) {
+	d := &deadlockDriver{}
+	sql.Register("deadlock", d)
+
+	db, err := sql.Open("deadlock", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	app := &application{
+		logger: jsonlog.New(io.Discard, jsonlog.LevelInfo),
+		models: data.NewModels(db),
+	}
+	app.config.batch.maxRequests = 10
+	app.config.batch.maxBytes = 1_048_576
+
+	body := `{"atomic": true, "requests": [{"method": "GET", "path": "/v1/movies/1"}, {"method": "GET", "path": "/v1/movies/2"}]}`
+
+	rr := httptest.NewRecorder()
+	app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d", rr.Code, http.StatusOK)
+	}
+
+	var response struct {
+		Results   []batchResult `json:"results"`
+		Committed bool          `json:"committed"`
+	}
+
+	err = json.NewDecoder(rr.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every attempt deadlocks, so the batch should be tried more than once, and still fail in the end.
+	if begins := d.begins.Load(); begins < 2 {
+		t.Errorf("got %d transactions; want the batch to be retried", begins)
+	}
+
+	if response.Committed {
+		t.Error("got committed batch; want it rolled back")
+	}
+
+	if len(response.Results) != 2 || response.Results[0].Status != http.StatusInternalServerError || response.Results[1].Status != http.StatusFailedDependency {
+		t.Errorf("got results %+v; want a 500 then a 424", response.Results)
+	}
+}
+
+func TestBatchRejectsStreamingRequests(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+	app.config.batch.maxRequests = 10
+	app.config.batch.maxBytes = 1_048_576
+
+	for _, path := range []string{"/v1/movies/export", "/v1/movies/events?genres=drama", "/v1/movies/./export", "/v1/blobs/posters/1/a/small.jpg"} {
+		body := fmt.Sprintf(`{"requests": [{"method": "GET", "path": "/v1/movies/1"}, {"method": "GET", "path": %q}]}`, path)
+
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/v1/batch", strings.NewReader(body)))
+
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: got status %d; want %d", path, rr.Code, http.StatusUnprocessableEntity)
+		}
+
+		if !strings.Contains(rr.Body.String(), `"requests[1]"`) {
+			t.Errorf("%s: got body %s; want an error for requests[1]", path, rr.Body.String())
+		}
+	}
+}
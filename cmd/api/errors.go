@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"greenlight/internal/data"
 	"net/http"
 )
 
@@ -31,9 +32,14 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 
 // The serverErrorResponse() method will be used when our application encounters an unexpected problem at runtime.
 // It logs the detailed error message, then uses the errorResponse() helper to send a 500 Internal Server Error status code and JSON response (containing a generic error message) to the client.
+// When the request is part of a batch, the error is also recorded for the batch (see batchResponseWriter).
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.logError(r, err)
 
+	if bw, ok := w.(*batchResponseWriter); ok && bw.err == nil {
+		bw.err = err
+	}
+
 	message := "the server encountered a problem and could not process your request"
 	app.errorResponse(w, r, http.StatusInternalServerError, message)
 }
@@ -64,3 +70,91 @@ func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Requ
 	message := "unable to update the record due to an edit conflict, please try again"
 	app.errorResponse(w, r, http.StatusConflict, message)
 }
+
+func (app *application) genreInUseResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to delete the genre because it is used by one or more movies, use the merge_into parameter to move them to another genre"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) personHasCreditsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "unable to delete the person because they are credited on one or more movies, use cascade=true to delete their credits as well"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid authentication credentials"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+// We include a WWW-Authenticate: Bearer header here, to help inform or remind the client that we expect them to authenticate using a bearer token.
+func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+
+	message := "invalid or missing authentication token"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you must be authenticated to access this resource"
+	app.errorResponse(w, r, http.StatusUnauthorized, message)
+}
+
+func (app *application) duplicateReviewResponse(w http.ResponseWriter, r *http.Request) {
+	message := "you have already reviewed this movie, use PUT to change your review"
+	app.errorResponse(w, r, http.StatusConflict, message)
+}
+
+func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "your user account doesn't have the necessary permissions to access this resource"
+	app.errorResponse(w, r, http.StatusForbidden, message)
+}
+
+// The goneResponse() method is sent for a movie that has been deleted, but is still in the trash and could be restored.
+func (app *application) goneResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested resource has been deleted"
+	app.errorResponse(w, r, http.StatusGone, message)
+}
+
+func (app *application) payloadTooLargeResponse(w http.ResponseWriter, r *http.Request, maxBytes int64) {
+	message := fmt.Sprintf("the request body must not be larger than %d bytes", maxBytes)
+	app.errorResponse(w, r, http.StatusRequestEntityTooLarge, message)
+}
+
+func (app *application) unsupportedMediaTypeResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusUnsupportedMediaType, message)
+}
+
+// The invalidPatchResponse() method is sent when a patch is well-formed JSON, but can't be applied to the resource.
+func (app *application) invalidPatchResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, err.Error())
+}
+
+// The patchTestFailedResponse() method is sent when a test operation in a JSON Patch finds that the resource isn't what the
+// client expected, so none of the patch was applied.
+func (app *application) patchTestFailedResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorResponse(w, r, http.StatusConflict, err.Error())
+}
+
+// The externalIDConflictResponse() method is sent when a movie is given an external ID that already belongs to another movie.
+// The response links to the other movie, in both the Location header and the body, when we know which one it is. If the other
+// movie is in the trash, fetching it would only get a 410, so instead of a Location header we give the URL for restoring it.
+func (app *application) externalIDConflictResponse(w http.ResponseWriter, r *http.Request, conflict *data.ExternalIDConflictError) {
+	env := envelope{"error": conflict.Error()}
+	headers := make(http.Header)
+
+	switch {
+	case conflict.MovieID != 0 && conflict.Deleted:
+		env["restore_movie"] = fmt.Sprintf("/v1/movies/%d/restore", conflict.MovieID)
+	case conflict.MovieID != 0:
+		url := fmt.Sprintf("/v1/movies/%d", conflict.MovieID)
+
+		env["existing_movie"] = url
+		headers.Set("Location", url)
+	}
+
+	err := app.writeJSON(w, http.StatusConflict, env, headers)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(500)
+	}
+}
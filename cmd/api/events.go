@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The settings for the movie event stream. A heartbeat comment is sent whenever the stream has been quiet for a while, so that
+// proxies don't close the connection as idle and clients can tell the stream is still alive. Each write gets its own
+// deadline, so a client that stops reading is dropped without the stream needing an overall time limit.
+const (
+	eventStreamHeartbeat    = 15 * time.Second
+	eventStreamWriteTimeout = 10 * time.Second
+	eventStreamRetry        = 5 * time.Second
+	eventStreamBuffer       = 64
+	eventStreamReplayPage   = 1000
+	eventStreamReplayLimit  = 5000
+)
+
+// The movieEventsHandler streams the change feed to the client as Server-Sent Events, so that it can follow changes to the
+// catalogue as they happen instead of polling the movie list. Each event's id is its seq, which an EventSource sends back in
+// the Last-Event-ID header when it reconnects, and the events it missed in the meantime are replayed before the stream carries
+// on. With the genres parameter, only events for movies that had all of those genres when the change was made are sent.
+//
+// Events are numbered when they're recorded but sent when they're committed, which isn't always in the same order, so the
+// client may have missed an event numbered before the last one it saw. Like the feed's catch-up, the replay starts
+// feedCatchUpLookback events earlier to cover those, and so it can repeat events the client already has. Each seq is only
+// ever used once, so clients should skip the events whose seq they've already seen.
+//
+// Replaying is limited to the last eventStreamReplayLimit events, so that it doesn't take so long that the live events pile up
+// behind it. A client that's further behind than that gets a reset event instead, whose id is the latest event's, and should
+// fetch whatever it's showing afresh before carrying on with the stream.
+//
+// The stream ends when the server shuts down, or when the client falls so far behind that the feed drops it. Either way the
+// client reconnects and resumes from the last event it saw.
+func (app *application) movieEventsHandler(w http.ResponseWriter, r *http.Request) {
+	v := validator.New()
+
+	genres := app.readCSV(r.URL.Query(), "genres", []string{})
+	v.Check(len(genres) <= 5, "genres", "must not contain more than 5 genres")
+
+	// An empty or missing Last-Event-ID means the client hasn't seen any events yet, so there's nothing to replay.
+	resume := r.Header.Get("Last-Event-ID") != ""
+
+	var lastEventID int64
+
+	if resume {
+		var err error
+
+		lastEventID, err = strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+		v.Check(err == nil && lastEventID >= 0, "Last-Event-ID", "must be the id of an event from this stream")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Events carry the genres the movie had when they were recorded, so we match them against the canonical names of the genres
+	// the client asked for. A movie's genres are always stored under their canonical names.
+	genres, err := app.resolveGenreNames(v, "genres", genres)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// The server's WriteTimeout would cut the stream off part way through, so we replace it with a deadline for each write (see
+	// eventStream.send()). Writers that can't set deadlines, like the one the batch endpoint uses, can't stream at all.
+	rc := http.NewResponseController(w)
+
+	err = rc.SetWriteDeadline(time.Now().Add(eventStreamWriteTimeout))
+	if err != nil {
+		switch {
+		case errors.Is(err, http.ErrNotSupported):
+			app.badRequestResponse(w, r, errors.New("the event stream can't be sent over this connection"))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Subscribe before replaying, so that events committed while we replay aren't missed. Those that turn up in both are
+	// usually only sent once (see below).
+	sub := app.feed.Subscribe(eventStreamBuffer)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx and similar proxies from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	stream := &eventStream{w: w, rc: rc, heartbeat: heartbeat}
+
+	err = stream.send(fmt.Sprintf("retry: %d\n\n", eventStreamRetry.Milliseconds()))
+	if err != nil {
+		return
+	}
+
+	if resume && lastEventID < sub.Start-eventStreamReplayLimit {
+		err = stream.send(fmt.Sprintf("id: %d\nevent: reset\ndata: {}\n\n", sub.Start))
+		if err != nil {
+			return
+		}
+
+		resume = false
+	}
+
+	// The subscription only carries the events published after sub.Start, so those are the only replayed events that can
+	// turn up again, and the only ones we need to remember. Each one is forgotten once it's come through the subscription.
+	replayed := map[int64]struct{}{}
+
+	for seq := max(lastEventID-feedCatchUpLookback, 0); resume; {
+		events, err := app.models.MovieEvents.Since(seq, eventStreamReplayPage)
+		if err != nil {
+			app.logError(r, err)
+			return
+		}
+
+		for _, event := range events {
+			if event.Seq > sub.Start {
+				replayed[event.Seq] = struct{}{}
+			}
+			seq = event.Seq
+
+			// The client certainly has the event it named, whatever else it has.
+			if event.Seq == lastEventID {
+				continue
+			}
+
+			err = app.sendMovieEvent(stream, event, genres)
+			if err != nil {
+				app.logStreamError(r, err)
+				return
+			}
+		}
+
+		resume = len(events) == eventStreamReplayPage
+	}
+
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+
+			if _, ok := replayed[event.Seq]; ok {
+				delete(replayed, event.Seq)
+				continue
+			}
+
+			err = app.sendMovieEvent(stream, event, genres)
+		case <-heartbeat.C:
+			err = stream.send(": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		}
+
+		if err != nil {
+			app.logStreamError(r, err)
+			return
+		}
+	}
+}
+
+// The sendMovieEvent() method sends an event on the stream, if the movie had the genres the client asked for.
+func (app *application) sendMovieEvent(stream *eventStream, event data.MovieEvent, genres []string) error {
+	if !event.HasGenres(genres) {
+		return nil
+	}
+
+	js, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	return stream.send(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Operation, js))
+}
+
+// The logStreamError() method logs an error that ended an event stream, unless it's just the client having gone away, which is
+// how streams normally end.
+func (app *application) logStreamError(r *http.Request, err error) {
+	if r.Context().Err() == nil && !errors.Is(err, errStreamWrite) {
+		app.logError(r, err)
+	}
+}
+
+// errStreamWrite wraps the errors from writing to an event stream, which almost always mean that the client has disconnected
+// or stopped reading.
+var errStreamWrite = errors.New("event stream write failed")
+
+// The eventStream type writes to an event stream. The heartbeat ticker is reset by every write, so heartbeats are only sent
+// when the stream has been quiet.
+type eventStream struct {
+	w         http.ResponseWriter
+	rc        *http.ResponseController
+	heartbeat *time.Ticker
+}
+
+// The send() method writes to the stream and flushes it to the client straight away, within a fresh write deadline.
+func (s *eventStream) send(message string) error {
+	err := s.rc.SetWriteDeadline(time.Now().Add(eventStreamWriteTimeout))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errStreamWrite, err)
+	}
+
+	_, err = s.w.Write([]byte(message))
+	if err != nil {
+		return fmt.Errorf("%w: %w", errStreamWrite, err)
+	}
+
+	err = s.rc.Flush()
+	if err != nil {
+		return fmt.Errorf("%w: %w", errStreamWrite, err)
+	}
+
+	s.heartbeat.Reset(eventStreamHeartbeat)
+
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many movies we write between flushes of the response, and how long each flush is allowed to take.
+// Every flush pushes the write deadline forward, so a long export isn't cut off by the server's WriteTimeout,
+// but a client which stops reading for longer than exportWriteTimeout still gets disconnected.
+const (
+	exportFlushEvery   = 500
+	exportWriteTimeout = 30 * time.Second
+)
+
+// A movieEncoder writes a stream of movies in one particular export format.
+// begin() is called once before the first movie, and end() once after the last one.
+// flush() pushes anything the encoder has buffered internally through to the underlying writer.
+type movieEncoder interface {
+	begin() error
+	encode(movie *data.Movie) error
+	flush() error
+	end() error
+}
+
+// The exportFormats map holds the content type and constructor for each supported value of the format query string parameter.
+var exportFormats = map[string]struct {
+	contentType string
+	extension   string
+	newEncoder  func(w io.Writer) movieEncoder
+}{
+	"csv":    {"text/csv; charset=utf-8", "csv", func(w io.Writer) movieEncoder { return &csvMovieEncoder{w: csv.NewWriter(w)} }},
+	"ndjson": {"application/x-ndjson", "ndjson", func(w io.Writer) movieEncoder { return &ndjsonMovieEncoder{w: w} }},
+	"json":   {"application/json", "json", func(w io.Writer) movieEncoder { return &jsonMovieEncoder{w: w} }},
+}
+
+// The csvMovieEncoder writes a header row followed by one row per movie. Because CSV has no native list type,
+// the genres are joined into a single field using a "|" separator.
+type csvMovieEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvMovieEncoder) begin() error {
+	return e.w.Write([]string{"id", "title", "year", "runtime", "genres", "version"})
+}
+
+func (e *csvMovieEncoder) encode(movie *data.Movie) error {
+	return e.w.Write([]string{
+		strconv.FormatInt(movie.ID, 10),
+		movie.Title,
+		strconv.FormatInt(int64(movie.Year), 10),
+		strconv.FormatInt(int64(movie.Runtime), 10),
+		strings.Join(movie.Genres, "|"),
+		strconv.FormatInt(int64(movie.Version), 10),
+	})
+}
+
+// The csv.Writer buffers internally, so we need to flush it before the response is flushed.
+func (e *csvMovieEncoder) flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvMovieEncoder) end() error {
+	return e.flush()
+}
+
+// The ndjsonMovieEncoder writes each movie as a standalone JSON object on its own line.
+type ndjsonMovieEncoder struct {
+	w io.Writer
+}
+
+func (e *ndjsonMovieEncoder) begin() error {
+	return nil
+}
+
+func (e *ndjsonMovieEncoder) encode(movie *data.Movie) error {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(append(js, '\n'))
+	return err
+}
+
+func (e *ndjsonMovieEncoder) flush() error {
+	return nil
+}
+
+func (e *ndjsonMovieEncoder) end() error {
+	return nil
+}
+
+// The jsonMovieEncoder writes the same {"movies": [...]} envelope as listMoviesHandler, but one element at a time
+// instead of marshalling the whole slice up front like writeJSON() does.
+type jsonMovieEncoder struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonMovieEncoder) begin() error {
+	_, err := io.WriteString(e.w, `{"movies":[`)
+	return err
+}
+
+func (e *jsonMovieEncoder) encode(movie *data.Movie) error {
+	js, err := json.Marshal(movie)
+	if err != nil {
+		return err
+	}
+
+	// Every element apart from the first needs a separating comma.
+	if e.count > 0 {
+		js = append([]byte{','}, js...)
+	}
+	e.count++
+
+	_, err = e.w.Write(js)
+	return err
+}
+
+func (e *jsonMovieEncoder) flush() error {
+	return nil
+}
+
+func (e *jsonMovieEncoder) end() error {
+	_, err := io.WriteString(e.w, "]}\n")
+	return err
+}
+
+// The exportMoviesHandler streams every movie matching the search criteria in CSV, NDJSON or JSON format.
+// Unlike listMoviesHandler there's no pagination: the rows are read from a database cursor and flushed to the client as we go.
+func (app *application) exportMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	// Accept the same search criteria as listMoviesHandler.
+	search := app.readMovieSearch(r, v)
+	format := app.readString(qs, "format", "json")
+
+	data.ValidateMovieSearch(v, search)
+	v.Check(validator.In(format, "csv", "ndjson", "json"), "format", "must be one of csv, ndjson or json")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err := app.resolveSearchGenres(v, &search)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	f := exportFormats[format]
+	enc := f.newEncoder(w)
+	rc := http.NewResponseController(w)
+
+	// The start() closure sends the headers and the opening of the document. We hold off calling it until the first
+	// movie arrives, so that if the query fails straight away we can still send a normal 500 error response.
+	// Once it's been called anything that goes wrong is logged and the response is simply cut short.
+	started := false
+
+	start := func() error {
+		started = true
+
+		w.Header().Set("Content-Type", f.contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.`+f.extension+`"`)
+		w.WriteHeader(http.StatusOK)
+
+		return enc.begin()
+	}
+
+	// The flush() closure pushes any buffered output to the client and extends the write deadline for the next batch.
+	flush := func() error {
+		err := enc.flush()
+		if err != nil {
+			return err
+		}
+
+		err = rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+		if err != nil {
+			return err
+		}
+
+		return rc.Flush()
+	}
+
+	written := 0
+
+	// Use the request context so that the database cursor is released as soon as the client disconnects.
+	err = app.models.Movies.Export(r.Context(), search, func(movie *data.Movie) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		err := enc.encode(movie)
+		if err != nil {
+			return err
+		}
+
+		written++
+		if written%exportFlushEvery == 0 {
+			return flush()
+		}
+
+		return nil
+	})
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logExportError(r, err)
+		return
+	}
+
+	// If nothing matched the filters we still need to send an empty document.
+	if !started {
+		err = start()
+	}
+	if err == nil {
+		err = enc.end()
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		app.logExportError(r, err)
+	}
+}
+
+// The logExportError() helper logs a failed export, unless it failed because the client went away, which is expected and not worth an ERROR entry.
+func (app *application) logExportError(r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) || r.Context().Err() != nil {
+		return
+	}
+
+	app.logError(r, err)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"greenlight/internal/data"
+	"sync"
+	"time"
+)
+
+// When the listener reconnects, the feed reads the events it missed from the database, starting this many events before
+// the last one it saw. Events are numbered when they're recorded but published when they're committed, which isn't always
+// in the same order, so an event numbered before the last one we saw can still turn up later. Looking back a little way
+// catches those, and events we've already published are skipped.
+const (
+	feedCatchUpLookback = 1000
+	feedCatchUpPageSize = 1000
+)
+
+// The movieFeed type fans the change feed's events out to any number of subscribers in this process, like the event stream
+// handler. Each subscriber has a buffered channel of its own. A subscriber that falls so far behind that its buffer fills up
+// is dropped, and its channel closed, rather than holding up everyone else; it can pick up where it left off by reading the
+// events it missed from the database.
+type movieFeed struct {
+	mu          sync.Mutex
+	closed      bool
+	events      data.MovieEventModel
+	last        int64              // The highest seq published so far
+	published   map[int64]struct{} // The seqs published recently, for skipping repeats
+	subscribers map[*movieSubscription]struct{}
+}
+
+// The movieSubscription type is a subscriber's view of the feed. Events arrive on C, in the order they were committed. Start
+// is the highest seq the feed had published when the subscriber joined, so the events on C are the ones published after it.
+type movieSubscription struct {
+	C     <-chan data.MovieEvent
+	Start int64
+	c     chan data.MovieEvent
+	feed  *movieFeed
+}
+
+// The newMovieFeed() function returns a feed which starts after the most recent event that's already been recorded.
+func newMovieFeed(events data.MovieEventModel) (*movieFeed, error) {
+	last, err := events.Latest()
+	if err != nil {
+		return nil, err
+	}
+
+	return &movieFeed{
+		events:      events,
+		last:        last,
+		published:   make(map[int64]struct{}),
+		subscribers: make(map[*movieSubscription]struct{}),
+	}, nil
+}
+
+// The Subscribe() method adds a subscriber whose channel can hold up to buffer events it hasn't read yet.
+func (f *movieFeed) Subscribe(buffer int) *movieSubscription {
+	c := make(chan data.MovieEvent, buffer)
+	s := &movieSubscription{C: c, c: c, feed: f}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s.Start = f.last
+
+	// Once the feed is closed, new subscribers get a closed channel straight away.
+	if f.closed {
+		close(c)
+		return s
+	}
+
+	f.subscribers[s] = struct{}{}
+
+	return s
+}
+
+// The Close() method closes every subscriber's channel, and stops any more events being published. It's called when the server
+// is shutting down, so that handlers streaming events finish and let the shutdown complete.
+func (f *movieFeed) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+
+	for s := range f.subscribers {
+		f.drop(s)
+	}
+}
+
+// The Close() method removes the subscriber from the feed, and closes its channel. It's safe to call more than once, and after
+// the subscriber has been dropped.
+func (s *movieSubscription) Close() {
+	s.feed.mu.Lock()
+	defer s.feed.mu.Unlock()
+
+	s.feed.drop(s)
+}
+
+// The drop() method removes a subscriber and closes its channel. The caller must hold the lock.
+func (f *movieFeed) drop(s *movieSubscription) {
+	if _, ok := f.subscribers[s]; ok {
+		delete(f.subscribers, s)
+		close(s.c)
+	}
+}
+
+// The publish() method sends an event to every subscriber, unless it's already been published.
+func (f *movieFeed) publish(event data.MovieEvent) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.published[event.Seq]; ok || f.closed {
+		return
+	}
+
+	f.published[event.Seq] = struct{}{}
+	f.last = max(f.last, event.Seq)
+
+	// Forget the seqs that are too old to be repeated by a catch-up, every so often, so the map doesn't keep growing.
+	if len(f.published) > 2*feedCatchUpLookback {
+		for seq := range f.published {
+			if seq <= f.last-feedCatchUpLookback {
+				delete(f.published, seq)
+			}
+		}
+	}
+
+	for s := range f.subscribers {
+		select {
+		case s.c <- event:
+		default:
+			f.drop(s)
+		}
+	}
+}
+
+// The catchUp() method publishes the events that were recorded while the listener was disconnected. The listener is listening
+// again by the time it's called, so anything committed after the catch-up reads the table arrives as a notification.
+func (f *movieFeed) catchUp() error {
+	f.mu.Lock()
+	seq := max(f.last-feedCatchUpLookback, 0)
+	f.mu.Unlock()
+
+	for {
+		events, err := f.events.Since(seq, feedCatchUpPageSize)
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			f.publish(event)
+			seq = event.Seq
+		}
+
+		if len(events) < feedCatchUpPageSize {
+			return nil
+		}
+	}
+}
+
+// The pruneMovieEvents() method runs in the background for as long as the server does, and deletes the events that are older
+// than the retention period every interval.
+func (app *application) pruneMovieEvents(interval, retention time.Duration) {
+	// Panics in this goroutine aren't caught by the recoverPanic() middleware, so we recover them here and log them instead.
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_, err := app.models.MovieEvents.Prune(retention)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strings"
+)
+
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.Genres.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createGenreHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name    string   `json:"name"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// Aliases are optional when creating a genre.
+	if input.Aliases == nil {
+		input.Aliases = []string{}
+	}
+
+	// Store the name and aliases in their canonical form, so that "Sci-Fi " and "sci-fi" are the same thing.
+	genre := &data.Genre{
+		Name:    data.CanonicalGenre(input.Name),
+		Aliases: canonicalGenres(input.Aliases),
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Insert(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("name", "a genre with this name or alias already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/genres/%d", genre.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"genre": genre}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	genre, err := app.models.Genres.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// As with updateMovieHandler, a nil value means the client didn't provide that field and we leave it unchanged.
+	// Providing aliases replaces the whole list.
+	var input struct {
+		Name    *string  `json:"name"`
+		Aliases []string `json:"aliases"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		genre.Name = data.CanonicalGenre(*input.Name)
+	}
+	if input.Aliases != nil {
+		genre.Aliases = canonicalGenres(input.Aliases)
+	}
+
+	v := validator.New()
+
+	if data.ValidateGenre(v, genre); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Update(genre)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateGenre):
+			v.AddError("name", "a genre with this name or alias already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genre": genre}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The deleteGenreHandler deletes a genre. If the genre is still used by any movies, the client has to say which genre those movies
+// should be moved to with the merge_into query string parameter; otherwise we send a 409 Conflict response.
+func (app *application) deleteGenreHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	mergeInto := app.readInt(r.URL.Query(), "merge_into", 0, v)
+
+	v.Check(mergeInto >= 0, "merge_into", "must be a positive integer")
+	v.Check(int64(mergeInto) != id, "merge_into", "must be a different genre")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Genres.Delete(id, int64(mergeInto))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrGenreInUse):
+			app.genreInUseResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "genre successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The resolveGenres() helper replaces the genre names on a movie with their canonical names, so that "Sci-Fi" is stored as
+// "science fiction". If any of the names don't match a genre or alias, we record a validation error listing them along with
+// the closest matching genres, and leave the movie unchanged.
+func (app *application) resolveGenres(v *validator.Validator, movie *data.Movie) error {
+	resolved, err := app.resolveGenreNames(v, "genres", movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	movie.Genres = resolved
+	return nil
+}
+
+// The resolveGenreNames() helper returns the canonical names of a list of genre names or aliases. If any of them don't match
+// a genre or alias, it records a validation error against key listing them along with the closest matching genres, and returns
+// the names unchanged.
+func (app *application) resolveGenreNames(v *validator.Validator, key string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return names, nil
+	}
+
+	resolved, unknown, err := app.models.Genres.Resolve(names)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(unknown) > 0 {
+		// Build the message in the order the client sent the genres, so it's predictable.
+		var problems []string
+
+		for _, name := range names {
+			suggestions, ok := unknown[name]
+			if !ok {
+				continue
+			}
+
+			problem := fmt.Sprintf("%q", name)
+			if len(suggestions) > 0 {
+				quoted := make([]string, len(suggestions))
+				for i, suggestion := range suggestions {
+					quoted[i] = fmt.Sprintf("%q", suggestion)
+				}
+				problem += " (did you mean " + strings.Join(quoted, " or ") + "?)"
+			}
+
+			problems = append(problems, problem)
+		}
+
+		v.AddError(key, "contains unknown genres: "+strings.Join(problems, ", "))
+		return names, nil
+	}
+
+	return resolved, nil
+}
+
+// The resolveSearchGenres() helper resolves the genre names in the movie search filters, so that a name which isn't a genre
+// or alias is reported to the client rather than silently matching nothing (or, for the "all of" filter, being ignored).
+// It should be called once the rest of the search has been validated, so the lists are known to be short.
+func (app *application) resolveSearchGenres(v *validator.Validator, search *data.MovieSearch) error {
+	var err error
+
+	search.Genres, err = app.resolveGenreNames(v, "genres", search.Genres)
+	if err != nil {
+		return err
+	}
+
+	search.GenresAny, err = app.resolveGenreNames(v, "genres_any", search.GenresAny)
+	if err != nil {
+		return err
+	}
+
+	search.GenresExclude, err = app.resolveGenreNames(v, "genres_exclude", search.GenresExclude)
+	return err
+}
+
+// The canonicalGenres() helper returns the canonical form of each genre name in a slice.
+func canonicalGenres(names []string) []string {
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		canonical[i] = data.CanonicalGenre(name)
+	}
+	return canonical
+}
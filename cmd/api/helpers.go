@@ -5,12 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
+	"greenlight/internal/data"
 	"greenlight/internal/validator"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Define an envelope type.
@@ -19,11 +22,16 @@ type envelope map[string]interface{}
 // Retrieve the "id" URL parameter from the current request context, then convert it to an integer and return it.
 // If the operation isn't successful, return 0 and an error.
 func (app *application) readIDParam(r *http.Request) (int64, error) {
+	return app.readNamedIDParam(r, "id")
+}
+
+// The readNamedIDParam() helper works like readIDParam(), but for routes with more than one ID in the URL, like /v1/movies/:id/credits/:credit_id.
+func (app *application) readNamedIDParam(r *http.Request, name string) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 
-	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
+	id, err := strconv.ParseInt(params.ByName(name), 10, 64)
 	if err != nil || id < 1 {
-		return 0, errors.New("invalid id parameter")
+		return 0, fmt.Errorf("invalid %s parameter", name)
 	}
 
 	return id, nil
@@ -58,9 +66,31 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data envelo
 	return nil
 }
 
+// The writeJSONPatch() helper sends a JSON Patch document. Unlike writeJSON(), the document is an array of operations rather than
+// an envelope, and it has its own media type.
+func (app *application) writeJSONPatch(w http.ResponseWriter, status int, ops []data.PatchOperation) error {
+	js, err := json.MarshalIndent(ops, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	js = append(js, '\n')
+
+	w.Header().Set("Content-Type", "application/json-patch+json")
+	w.WriteHeader(status)
+	w.Write(js)
+
+	return nil
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) error {
-	// Use http.MaxBytesReader() to limit the size of the request body to 1MB.
-	maxBytes := 1_048_576
+	return app.readJSONWithLimit(w, r, dst, 1_048_576)
+}
+
+// The readJSONWithLimit() helper works like readJSON(), but with a different limit on the size of the request body, for
+// endpoints like the batch endpoint that need to accept more than 1MB.
+func (app *application) readJSONWithLimit(w http.ResponseWriter, r *http.Request, dst interface{}, maxBytes int) error {
+	// Use http.MaxBytesReader() to limit the size of the request body to maxBytes.
 	r.Body = http.MaxBytesReader(w, r.Body, int64(maxBytes))
 
 	// Initialize the json.Decoder, and call the DisallowUnknownFields() method on it
@@ -192,3 +222,117 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	// Otherwise, return the converted integer value
 	return i
 }
+
+// The readTime() helper reads a timestamp from the query string. Both full RFC 3339 timestamps and plain dates in the
+// format "2006-01-02" (which are treated as midnight UTC) are accepted. If no matching key could be found it returns the
+// provided default value, and if the value can't be parsed we record an error message in the provided Validator instance.
+func (app *application) readTime(qs url.Values, key string, defaultValue time.Time, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t
+		}
+	}
+
+	v.AddError(key, "must be an RFC 3339 timestamp or a date in the format YYYY-MM-DD")
+	return defaultValue
+}
+
+// The readBool() helper reads a boolean value from the query string. It accepts the same values as strconv.ParseBool(), like "true", "false", "1" and "0".
+// If no matching key could be found it returns the provided default value, and if the value isn't a boolean we record an error message in the provided Validator instance.
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// The readLanguages() helper returns the languages the client would like localized content in, most preferred first, as
+// canonical BCP 47 tags. A comma-separated list in the lang query string parameter takes priority; otherwise we use the
+// Accept-Language header. Invalid tags in lang are recorded in the provided Validator instance, but invalid entries in the
+// header are just skipped, since browsers send it whether or not the client asked for anything.
+func (app *application) readLanguages(r *http.Request, v *validator.Validator) []string {
+	languages := []string{}
+
+	if lang := r.URL.Query().Get("lang"); lang != "" {
+		for _, tag := range strings.Split(lang, ",") {
+			tag = strings.TrimSpace(tag)
+
+			if !data.LanguageTagRX.MatchString(tag) {
+				v.AddError("lang", "must contain only valid language tags, like en or pt-BR")
+				return nil
+			}
+
+			languages = append(languages, data.CanonicalLanguageTag(tag))
+		}
+
+		return languages
+	}
+
+	// Each entry in the header is a language tag with an optional quality value, like "de-AT, de;q=0.8, en;q=0.5".
+	// Entries without a quality value have a quality of 1, and a quality of 0 means "not acceptable".
+	type weighted struct {
+		tag     string
+		quality float64
+	}
+
+	entries := []weighted{}
+
+	for _, entry := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		tag = strings.TrimSpace(tag)
+
+		quality := 1.0
+
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+
+		if quality <= 0 || !data.LanguageTagRX.MatchString(tag) {
+			continue
+		}
+
+		entries = append(entries, weighted{data.CanonicalLanguageTag(tag), quality})
+	}
+
+	// Keep entries with the same quality in the order the client gave them.
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].quality > entries[j].quality
+	})
+
+	for _, entry := range entries {
+		languages = append(languages, entry.tag)
+	}
+
+	return languages
+}
+
+// The setContentLanguage() helper adds the headers for a response that's been localized for the client's languages. The
+// response varies with the Accept-Language header, and Content-Language lists the languages of the localized titles it
+// contains, if there are any.
+func (app *application) setContentLanguage(w http.ResponseWriter, used []string) {
+	w.Header().Add("Vary", "Accept-Language")
+
+	if len(used) > 0 {
+		w.Header().Set("Content-Language", strings.Join(used, ", "))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"greenlight/internal/data"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The newListener() function returns a pq.Listener listening for the notifications the database sends about changes to
+// movies: the change feed's events, and, if the movie cache is turned on, the cache invalidations. It has its own connection
+// to the primary, outside the pool. If that connection is lost, the listener reconnects by itself, waiting twice as long
+// after each failed attempt, up to a minute.
+func (app *application) newListener() (*pq.Listener, error) {
+	listener := pq.NewListener(app.config.db.dsn, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		switch event {
+		case pq.ListenerEventDisconnected, pq.ListenerEventConnectionAttemptFailed:
+			app.logger.PrintError(fmt.Errorf("database listener: %w", err), nil)
+		case pq.ListenerEventReconnected:
+			app.logger.PrintInfo("database listener reconnected", nil)
+		}
+	})
+
+	channels := []string{data.MovieEventsChannel}
+	if app.models.Movies.Cache != nil {
+		channels = append(channels, data.MovieCacheChannel)
+	}
+
+	for _, channel := range channels {
+		err := listener.Listen(channel)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+// The listen() method runs in the background for as long as the server does, and passes the listener's notifications on:
+// events to the change feed, and invalidations to the movie cache.
+//
+// After reconnecting, the listener sends a nil notification, because any notifications sent while it was disconnected have
+// been lost. The cache makes up for that by emptying itself, and the feed by reading the events it missed from the database.
+// A connection which has silently died can't tell us so, so if there's been no notification for a while we ping it, which
+// makes the listener notice and reconnect.
+func (app *application) listen(listener *pq.Listener) {
+	// Panics in this goroutine aren't caught by the recoverPanic() middleware, so we recover them here and log them instead.
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	for {
+		select {
+		case n, ok := <-listener.Notify:
+			if !ok {
+				return
+			}
+
+			if n == nil {
+				app.models.Movies.Cache.Flush()
+
+				err := app.feed.catchUp()
+				if err != nil {
+					app.logger.PrintError(fmt.Errorf("movie feed catch-up: %w", err), nil)
+				}
+				continue
+			}
+
+			switch n.Channel {
+			case data.MovieEventsChannel:
+				event, err := data.ParseMovieEvent(n.Extra)
+				if err != nil {
+					app.logger.PrintError(fmt.Errorf("movie feed: %w", err), map[string]string{"payload": n.Extra})
+					continue
+				}
+
+				app.feed.publish(event)
+			case data.MovieCacheChannel:
+				app.models.Movies.Cache.HandleNotification(n)
+			}
+		case <-time.After(90 * time.Second):
+			go listener.Ping()
+		}
+	}
+}
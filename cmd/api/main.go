@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
 	"database/sql"
+	"errors"
+	"expvar"
 	"flag"
+	"fmt"
+	"greenlight/internal/blob"
 	"greenlight/internal/data"
 	"greenlight/internal/jsonlog"
 	"os"
+	"strconv"
 	"time"
 
 	_ "github.com/lib/pq"
@@ -32,6 +38,61 @@ type config struct {
 		maxIdleConns int
 		maxIdleTime  string
 		sdsd         string
+		// The read replicas, how far one can fall behind the primary before it's taken out of rotation, how often they're
+		// checked, and how long a client's reads go to the primary after it makes a change.
+		replicaDSNs          []string
+		replicaMaxLag        time.Duration
+		replicaCheckInterval time.Duration
+		stickyWindow         time.Duration
+	}
+	// The secret used to sign the pagination cursors we hand out to clients.
+	cursor struct {
+		secret string
+	}
+	// The PostgreSQL text search configuration used for full-text search over movies.
+	search struct {
+		config      string
+		titleConfig string
+	}
+	// How long we're prepared to wait for facet counts on the movie list before sending it without them.
+	facets struct {
+		timeout time.Duration
+	}
+	// Where uploaded files like posters are kept: "local" for a directory on this server, or "s3" for an S3-compatible service.
+	// Orphaned blobs are garbage collected every gcInterval, once they've been orphaned for longer than gcGrace.
+	blob struct {
+		store      string
+		dir        string
+		baseURL    string
+		gcInterval time.Duration
+		gcGrace    time.Duration
+	}
+	// The settings for the S3 blob store.
+	s3 struct {
+		endpoint  string
+		region    string
+		bucket    string
+		accessKey string
+		secretKey string
+		publicURL string
+	}
+	// How long deleted movies stay in the trash before an admin can purge them.
+	trash struct {
+		retention time.Duration
+	}
+	// The limits on the number of requests in a batch, and the size of its body.
+	batch struct {
+		maxRequests int
+		maxBytes    int
+	}
+	// How many movies the movie cache holds, and for how long. A size of 0 turns the cache off.
+	cache struct {
+		size int
+		ttl  time.Duration
+	}
+	// How long the change feed's events are kept for listeners to catch up with.
+	events struct {
+		retention time.Duration
 	}
 }
 
@@ -42,6 +103,9 @@ type application struct {
 	config config
 	logger *jsonlog.Logger
 	models data.Models
+	blobs  blob.Store
+	writes *writeTracker
+	feed   *movieFeed
 }
 
 func main() {
@@ -59,6 +123,50 @@ func main() {
 	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
 	flag.StringVar(&cfg.db.maxIdleTime, "db-max-idle-time", "15m", "PostgreSQL max connection idle time")
 
+	// The -db-replica-dsn flag can be given once for each read replica, so we collect its values with flag.Func().
+	flag.Func("db-replica-dsn", "PostgreSQL DSN for a read replica (can be given more than once)", func(dsn string) error {
+		cfg.db.replicaDSNs = append(cfg.db.replicaDSNs, dsn)
+		return nil
+	})
+	flag.DurationVar(&cfg.db.replicaMaxLag, "db-replica-max-lag", 2*time.Second, "How far a read replica can fall behind the primary before it's taken out of rotation")
+	flag.DurationVar(&cfg.db.replicaCheckInterval, "db-replica-check-interval", 5*time.Second, "How often to check the read replicas")
+	flag.DurationVar(&cfg.db.stickyWindow, "db-sticky-window", 5*time.Second, "How long a client's reads go to the primary after it makes a change")
+
+	// Read the pagination cursor signing secret, falling back to the GREENLIGHT_CURSOR_SECRET environment variable.
+	flag.StringVar(&cfg.cursor.secret, "cursor-secret", os.Getenv("GREENLIGHT_CURSOR_SECRET"), "Secret used to sign pagination cursors")
+
+	// Read the text search configuration. Any configuration installed in the database can be used, like "english" to get stemming.
+	flag.StringVar(&cfg.search.config, "search-config", "simple", "PostgreSQL text search configuration for movie search")
+	// The title filter only uses the index on titles with "simple", which is what the index is built with.
+	flag.StringVar(&cfg.search.titleConfig, "search-title-config", "simple", "PostgreSQL text search configuration for the movie title filter")
+
+	// Read the facet timeout. flag.DurationVar() parses values like "500ms" or "2s" for us.
+	flag.DurationVar(&cfg.facets.timeout, "facets-timeout", 500*time.Millisecond, "Maximum time to spend counting facets for the movie list")
+
+	// Read the blob store settings. The S3 credentials fall back to the standard AWS environment variables.
+	flag.StringVar(&cfg.blob.store, "blob-store", "local", "Blob store for uploaded files (local|s3)")
+	flag.StringVar(&cfg.blob.dir, "blob-dir", "./blobs", "Directory for the local blob store")
+	flag.StringVar(&cfg.blob.baseURL, "blob-base-url", "/v1/blobs", "URL prefix the local blob store is served from")
+	flag.DurationVar(&cfg.blob.gcInterval, "blob-gc-interval", 10*time.Minute, "How often to delete orphaned blobs")
+	flag.DurationVar(&cfg.blob.gcGrace, "blob-gc-grace", time.Hour, "How long a blob must have been orphaned before it's deleted")
+
+	flag.StringVar(&cfg.s3.endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3 endpoint URL")
+	flag.StringVar(&cfg.s3.region, "s3-region", "us-east-1", "S3 region")
+	flag.StringVar(&cfg.s3.bucket, "s3-bucket", os.Getenv("GREENLIGHT_S3_BUCKET"), "S3 bucket")
+	flag.StringVar(&cfg.s3.accessKey, "s3-access-key", os.Getenv("AWS_ACCESS_KEY_ID"), "S3 access key ID")
+	flag.StringVar(&cfg.s3.secretKey, "s3-secret-key", os.Getenv("AWS_SECRET_ACCESS_KEY"), "S3 secret access key")
+	flag.StringVar(&cfg.s3.publicURL, "s3-public-url", "", "URL prefix blobs are downloaded from (defaults to the endpoint and bucket)")
+
+	flag.DurationVar(&cfg.trash.retention, "trash-retention", 30*24*time.Hour, "How long deleted movies are kept before they can be purged")
+
+	flag.IntVar(&cfg.batch.maxRequests, "batch-max-requests", 100, "Maximum number of requests in a batch")
+	flag.IntVar(&cfg.batch.maxBytes, "batch-max-bytes", 4_194_304, "Maximum size of a batch request body in bytes")
+
+	flag.IntVar(&cfg.cache.size, "cache-size", 10_000, "Maximum number of movies to cache (0 turns the cache off)")
+	flag.DurationVar(&cfg.cache.ttl, "cache-ttl", 5*time.Minute, "Maximum time to cache a movie for")
+
+	flag.DurationVar(&cfg.events.retention, "events-retention", 7*24*time.Hour, "How long to keep movie change events")
+
 	flag.Parse()
 
 	// Initialize a new logger which writes messages to the standard out stream, prefixed with the current date and time.
@@ -66,9 +174,23 @@ func main() {
 	// severity level to the standard out stream.
 	logger := jsonlog.New(os.Stdout, jsonlog.LevelInfo)
 
+	// If no cursor secret was provided, generate a random one. Cursors then only stay valid until the server restarts,
+	// and won't work across multiple instances, so it's fine for development but should be set in production.
+	if cfg.cursor.secret == "" {
+		secret := make([]byte, 32)
+
+		_, err := rand.Read(secret)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+
+		cfg.cursor.secret = string(secret)
+		logger.PrintInfo("no cursor secret provided, using a random one", nil)
+	}
+
 	// Call the openDB() helper function (see below) to create the connection pool, passing in the config struct.
 	// If this returns an error, we log it and exit the application immediately.
-	db, err := openDB(cfg)
+	db, err := openDB(cfg, cfg.db.dsn)
 	if err != nil {
 		// Use the PrintFatal() method to write a log entry containing the error at the FATAL level and exit.
 		// We have no additional properties to include in the log entry.
@@ -83,12 +205,80 @@ func main() {
 
 	// Declare an instance of the application struct, containing the config struct and the logger
 	// Use the data.NewModels() function to initialize a Models struct, passing in the connection pool as a parameter
+	models := data.NewModels(db)
+
+	// Use the configured text search configurations for indexing and searching movies.
+	models.Movies.SearchConfig = cfg.search.config
+	models.Movies.TitleSearchConfig = cfg.search.titleConfig
+
+	// Open the read replicas, if there are any. A replica that can't be reached doesn't stop the server starting; it's just
+	// left out of rotation until it recovers.
+	if len(cfg.db.replicaDSNs) > 0 {
+		replicas, err := openReplicas(cfg)
+		if err != nil {
+			logger.PrintFatal(err, nil)
+		}
+		defer replicas.Close()
+
+		models.Movies.Replicas = replicas
+	}
+
+	blobs, err := openBlobStore(cfg)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
 	app := &application{
 		config: cfg,
 		logger: logger,
-		models: data.NewModels(db),
+		models: models,
+		blobs:  blobs,
+	}
+
+	// Check the read replicas before we start serving, so that unhealthy ones are never used, and then keep checking them in
+	// the background. Clients only need to be sent back to the primary after a change when there are replicas to avoid.
+	if models.Movies.Replicas != nil {
+		app.checkReplicas(cfg.db.replicaMaxLag)
+		app.writes = newWriteTracker(cfg.db.stickyWindow)
+
+		go app.monitorReplicas(cfg.db.replicaCheckInterval, cfg.db.replicaMaxLag)
+
+		logger.PrintInfo("read replicas configured", map[string]string{"count": strconv.Itoa(len(cfg.db.replicaDSNs))})
+	}
+
+	// Cache movies. The cache's counters are published with the other metrics at /debug/vars.
+	if cfg.cache.size > 0 {
+		cache := data.NewMovieCache(cfg.cache.size, cfg.cache.ttl)
+		app.models.Movies.Cache = cache
+
+		expvar.Publish("movie_cache", expvar.Func(func() interface{} {
+			return cache.Stats()
+		}))
 	}
 
+	// Listen for changes to movies, to feed them to the change feed's subscribers and invalidate the movie cache, whichever
+	// instance makes them. We start listening before the feed looks up where it starts, so no event can fall in between.
+	listener, err := app.newListener()
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+	defer listener.Close()
+
+	app.feed, err = newMovieFeed(models.MovieEvents)
+	if err != nil {
+		logger.PrintFatal(err, nil)
+	}
+
+	go app.listen(listener)
+	go app.pruneMovieEvents(time.Hour, cfg.events.retention)
+
+	// Start deleting orphaned blobs in the background.
+	go app.collectOrphanedBlobs(cfg.blob.gcInterval, cfg.blob.gcGrace)
+
+	// Move any movies indexed with a different text search configuration onto the current one, in the background. Until it's
+	// done, full-text searches may miss some of them.
+	go app.reindexMovies()
+
 	// Declare an HTTP server with some sensible timeout settings, which listens on the port provided in the config struct and uses the serve mux we created above as the handler
 	// srv := &http.Server{
 	// Addr:         fmt.Sprintf(":%d", cfg.port),
@@ -118,33 +308,48 @@ func main() {
 	// logger.PrintFatal(err, nil)
 }
 
-// The openDB() function returns a sql.DB connection pool
-func openDB(cfg config) (*sql.DB, error) {
+// The openBlobStore() function returns the blob store chosen by the -blob-store flag.
+func openBlobStore(cfg config) (blob.Store, error) {
+	switch cfg.blob.store {
+	case "local":
+		return &blob.LocalStore{Dir: cfg.blob.dir, BaseURL: cfg.blob.baseURL}, nil
+	case "s3":
+		if cfg.s3.bucket == "" || cfg.s3.accessKey == "" || cfg.s3.secretKey == "" {
+			return nil, errors.New("the s3 blob store needs a bucket, access key and secret key")
+		}
+
+		return &blob.S3Store{
+			Endpoint:  cfg.s3.endpoint,
+			Region:    cfg.s3.region,
+			Bucket:    cfg.s3.bucket,
+			AccessKey: cfg.s3.accessKey,
+			SecretKey: cfg.s3.secretKey,
+			PublicURL: cfg.s3.publicURL,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", cfg.blob.store)
+	}
+}
+
+// The openDB() function returns a sql.DB connection pool for the database with the given DSN, which is the primary's DSN
+// from the config struct except for read replicas.
+func openDB(cfg config, dsn string) (*sql.DB, error) {
 	// Use sql.Open() to create an empty connection pool, using the DSN from the config struct.
 	// How does the sql.DB connection pool work?
 	// Important thing to know is, sql.DB pool contains two types of connections, 'in-use' and 'idle' connections.
 	// A connection is marked as in-use when you are using it to perform a database task, such as executing a SQL statement.
 	// When the task is complete the connection is then marked as idle.
-	db, err := sql.Open("postgres", cfg.db.dsn)
+	db, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return nil, err
 	}
 
-	// Set the maximum number of open (in-use +idle) connections in the pool. Note that passing a value less than or equal to 0 will mean there is no limit.
-	db.SetMaxOpenConns(cfg.db.maxOpenConns)
-
-	// Set the maximum number of idle connections in the pool. Again, passing a value less than or equal to 0 mean there is no limit
-	db.SetMaxIdleConns(cfg.db.maxIdleConns)
-
-	// Use the time.ParseDuration() function to convert the idle timeout durantion string to a time.Duration type.
-	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+	err = setPoolLimits(db, cfg)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	// Set the maximum idle timeout
-	db.SetConnMaxIdleTime(duration)
-
 	// Create a context with a 5 seconds timeout deadline
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -154,9 +359,53 @@ func openDB(cfg config) (*sql.DB, error) {
 	// Then this will return an error.
 	err = db.PingContext(ctx)
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	// Return the sql.DB connection pool.
 	return db, nil
 }
+
+// The setPoolLimits() function applies the connection pool settings from the config struct to a pool.
+func setPoolLimits(db *sql.DB, cfg config) error {
+	// Set the maximum number of open (in-use +idle) connections in the pool. Note that passing a value less than or equal to 0 will mean there is no limit.
+	db.SetMaxOpenConns(cfg.db.maxOpenConns)
+
+	// Set the maximum number of idle connections in the pool. Again, passing a value less than or equal to 0 mean there is no limit
+	db.SetMaxIdleConns(cfg.db.maxIdleConns)
+
+	// Use the time.ParseDuration() function to convert the idle timeout durantion string to a time.Duration type.
+	duration, err := time.ParseDuration(cfg.db.maxIdleTime)
+	if err != nil {
+		return err
+	}
+
+	// Set the maximum idle timeout
+	db.SetConnMaxIdleTime(duration)
+
+	return nil
+}
+
+// The openReplicas() function returns a ReplicaSet with a connection pool for each of the read replicas. Unlike openDB(), it
+// doesn't ping them: whether they're reachable is up to the replica checks, which take the ones that aren't out of rotation.
+func openReplicas(cfg config) (*data.ReplicaSet, error) {
+	var dbs []*sql.DB
+
+	for _, dsn := range cfg.db.replicaDSNs {
+		db, err := sql.Open("postgres", dsn)
+		if err == nil {
+			err = setPoolLimits(db, cfg)
+		}
+		if err != nil {
+			for _, db := range dbs {
+				db.Close()
+			}
+			return nil, err
+		}
+
+		dbs = append(dbs, db)
+	}
+
+	return data.NewReplicaSet(dbs), nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+)
+
+// The metrics the metricsHandler serves. These are the only expvar variables we publish ourselves; expvar's own cmdline and
+// memstats are left out on purpose, since cmdline includes any DSN, secret or key that was passed as a flag.
+var publishedMetrics = []string{"movie_cache"}
+
+// The metricsHandler serves our published metrics, like the movie cache's hit and miss counters, in the same JSON format as
+// expvar.Handler(). It's only for admins.
+func (app *application) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	metrics := envelope{}
+
+	for _, name := range publishedMetrics {
+		if v := expvar.Get(name); v != nil {
+			metrics[name] = json.RawMessage(v.String())
+		}
+	}
+
+	err := app.writeJSON(w, http.StatusOK, metrics, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
 	"net/http"
+	"strings"
 )
 
 // It's important to note that our middleware will only recover panics that happen in the same goroutine that executed it.
@@ -26,3 +30,89 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// The authenticate() middleware looks for a bearer token in the Authorization header and, if there is one, adds the user it belongs to
+// to the request context. Requests without an Authorization header carry on as the AnonymousUser.
+func (app *application) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Add the "Vary: Authorization" header to the response. This indicates to any caches that the response may vary based on
+		// the value of the Authorization header in the request.
+		w.Header().Add("Vary", "Authorization")
+
+		authorizationHeader := r.Header.Get("Authorization")
+
+		// If there is no Authorization header found, use the contextSetUser() helper to add the AnonymousUser to the request context.
+		// Then we call the next handler in the chain and return without executing any of the code below.
+		if authorizationHeader == "" {
+			r = app.contextSetUser(r, data.AnonymousUser)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Otherwise, we expect the value of the Authorization header to be in the format "Bearer <token>". We try to split this
+		// into its constituent parts, and if the header isn't in the expected format we return a 401 Unauthorized response.
+		headerParts := strings.Split(authorizationHeader, " ")
+		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		token := headerParts[1]
+
+		v := validator.New()
+
+		if data.ValidateTokenPlaintext(v, token); !v.Valid() {
+			app.invalidAuthenticationTokenResponse(w, r)
+			return
+		}
+
+		// Retrieve the details of the user associated with the authentication token. If no matching record was found
+		// (or the token has expired), send an invalidAuthenticationTokenResponse.
+		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.invalidAuthenticationTokenResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		r = app.contextSetUser(r, user)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The requireAuthenticatedUser() middleware wraps a handler that needs to know who the user is, and sends a 401 Unauthorized
+// response to anonymous requests. Notice that it accepts and returns a http.HandlerFunc, so we can wrap individual routes with it.
+func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if user.IsAnonymous() {
+			app.authenticationRequiredResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+// The requireAdmin() middleware only lets admin users through. Anonymous requests get a 401 Unauthorized response from
+// requireAuthenticatedUser(), and other users get a 403 Forbidden response.
+func (app *application) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		user := app.contextGetUser(r)
+
+		if !user.Admin {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+
+	return app.requireAuthenticatedUser(fn)
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"greenlight/internal/data"
+	"greenlight/internal/jsonpatch"
 	"greenlight/internal/validator"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Add a shownMovieHandler for the "GET /v1/movies/:id" endpoint.
@@ -21,12 +28,25 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Work out which languages the client would like the title in.
+	v := validator.New()
+
+	languages := app.readLanguages(r, v)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
 	// Call the Get() method to fetch the data for a specific movie.
 	// We also need to use the errors.Is() function, to check if it returns a data.ErrRecordNotFound error,
 	// In which case we send a 404 Not Found response to the client.
-	movie, err := app.models.Movies.Get(id)
+	// A movie that's in the trash gets a 410 Gone response instead. ErrRecordDeleted wraps ErrRecordNotFound, so it's checked first.
+	// The movie is read from a replica, unless the client has just made a change (see readMovies()).
+	movie, err := app.readMovies(r).Get(id)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrRecordDeleted):
+			app.goneResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -35,6 +55,14 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// Swap in the best localized title for the client, keeping the original title alongside it.
+	used := []string{}
+	if tag := movie.Localize(languages); tag != "" {
+		used = append(used, tag)
+	}
+
+	app.setContentLanguage(w, used)
+
 	// Encode the struct to JSON and send it as the HTTP response
 	// Create an envelope{"movie": movie} instance and pass it to writeJSON(), instead of passing the plain movie struct
 	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
@@ -48,10 +76,15 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// HTTP request body (note that the field names and types in the struct are a subset of the Movie struct that we created earlier).
 	// This struct will be our *target decode destination*
 	var input struct {
-		Title   string       `json:"title"`
-		Year    int32        `json:"year"`
-		Runtime data.Runtime `json:"runtime"`
-		Genres  []string     `json:"genres"`
+		Title       string            `json:"title"`
+		Year        int32             `json:"year"`
+		Runtime     data.Runtime      `json:"runtime"`
+		Genres      []string          `json:"genres"`
+		ExternalIDs map[string]string `json:"external_ids"`
+		Titles      map[string]string `json:"titles"`
+		Status      string            `json:"status"`
+		ReleaseDate string            `json:"release_date"`
+		Releases    map[string]string `json:"releases"`
 	}
 
 	// Initialize a new json.Decoder instance which reads from the request body, and then the Decode() method to decode the body contents into the input struct.
@@ -72,15 +105,32 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	// and the corresponding values would be decoded without any error into the ID and Version fields of the Movie struct
 	// Note that the movie variable contains a *pointer* to a Movie struct.
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:       input.Title,
+		Year:        input.Year,
+		Runtime:     input.Runtime,
+		Genres:      input.Genres,
+		ExternalIDs: input.ExternalIDs,
+		Titles:      canonicalTitles(input.Titles),
+		Status:      input.Status,
+		ReleaseDate: input.ReleaseDate,
+		Releases:    canonicalReleases(input.Releases),
+	}
+
+	// Movies are released unless we're told otherwise.
+	if movie.Status == "" {
+		movie.Status = data.StatusReleased
 	}
 
 	// Initialize a new Validator instance
 	v := validator.New()
 
+	// Swap the genre names for their canonical names, recording a validation error for any that we don't recognize.
+	err = app.resolveGenres(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
 	// Use the Valid() method to see if any of the checks failed. If they did, then use the failedValidationResponse() helper to send a response to the client, passing in the v.Errors map.
 	if data.ValidateMovie(v, movie); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
@@ -89,9 +139,22 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 
 	// Call the Insert() method on our movies model, passing in a pointer to the validated movie struct.
 	// This will create a record in the database and update the movie struct with the system generated information.
-	err = app.models.Movies.Insert(movie)
+	// A genre could have been deleted since we resolved the names, in which case we treat it like any other unknown genre.
+	// If one of the movie's external IDs already belongs to another movie, it's most likely a duplicate of that movie, and we tell
+	// the client where to find it.
+	err = app.models.Movies.Insert(movie, app.contextGetUser(r))
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		var conflictErr *data.ExternalIDConflictError
+
+		switch {
+		case errors.As(err, &conflictErr):
+			app.externalIDConflictResponse(w, r, conflictErr)
+		case errors.Is(err, data.ErrUnknownGenre):
+			v.AddError("genres", "contains unknown genres")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -128,22 +191,95 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// The changes can be sent in one of three formats, told apart by the Content-Type header: our own JSON input (the default),
+	// a JSON Merge Patch (RFC 7396) or a JSON Patch (RFC 6902).
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	switch mediaType {
+	case "", "application/json":
+		err = app.readMovieUpdate(w, r, movie)
+	case "application/merge-patch+json", "application/json-patch+json":
+		err = app.readMoviePatch(w, r, movie, mediaType)
+	default:
+		app.unsupportedMediaTypeResponse(w, r, "the request body must be application/json, application/merge-patch+json or application/json-patch+json")
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, jsonpatch.ErrTestFailed):
+			app.patchTestFailedResponse(w, r, err)
+		case errors.Is(err, jsonpatch.ErrInvalidOperation):
+			app.invalidPatchResponse(w, r, err)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	// Validate the updated movie record, sending the client a 422 Unprocessable Entity response if any checks fail.
+	v := validator.New()
+
+	err = app.resolveGenres(v, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateMovie(v, movie); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Pass the update movie a record to our new Update() method.
+	// Intercept any ErrEditConflict error and call the new editConflictResponse() helper
+	err = app.models.Movies.Update(movie, app.contextGetUser(r))
+	if err != nil {
+		var conflictErr *data.ExternalIDConflictError
+
+		switch {
+		case errors.As(err, &conflictErr):
+			app.externalIDConflictResponse(w, r, conflictErr)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownGenre):
+			v.AddError("genres", "contains unknown genres")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Write the updated movie record in a JSON response.s
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The readMovieUpdate() helper reads the changes to a movie from a request body in our own JSON format, and applies them to
+// the movie. Fields that aren't in the body are left unchanged.
+func (app *application) readMovieUpdate(w http.ResponseWriter, r *http.Request, movie *data.Movie) error {
 	// Declare an input struct to hold the expected data from the client.
 	// Use Pointers for the Title, Year and Runtime field. Since 0 is the no value of pointers we should use pointers
 	// To summarize: we've change the input struct so that all the fields now have the zero-value nil.
 	// After parsing the JSON request, we then go through the input struct fields and only update the movie record if the new value is not nil.
 	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+		Title       *string           `json:"title"`
+		Year        *int32            `json:"year"`
+		Runtime     *data.Runtime     `json:"runtime"`
+		Genres      []string          `json:"genres"`
+		ExternalIDs map[string]string `json:"external_ids"`
+		Titles      map[string]string `json:"titles"`
+		Status      *string           `json:"status"`
+		ReleaseDate *string           `json:"release_date"`
+		Releases    map[string]string `json:"releases"`
 	}
 
 	// Read the JSON request body data into the input struct.
-	err = app.readJSON(w, r, &input)
+	err := app.readJSON(w, r, &input)
 	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+		return err
 	}
 
 	// If the input.Title value is nil then we know that no corresponding "title" key/value pair was provided in the JSON request body.
@@ -164,53 +300,129 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 	if input.Genres != nil {
 		movie.Genres = input.Genres // Note that we don't need to dereference a slice
 	}
-
-	// Validate the updated movie record, sending the client a 422 Unprocessable Entity response if any checks fail.
-	v := validator.New()
-
-	if data.ValidateMovie(v, movie); !v.Valid() {
-		app.failedValidationResponse(w, r, v.Errors)
-		return
+	// Like the genres, the external IDs are replaced as a whole, so an ID can be removed by leaving it out.
+	if input.ExternalIDs != nil {
+		movie.ExternalIDs = input.ExternalIDs
+	}
+	if input.Titles != nil {
+		movie.Titles = canonicalTitles(input.Titles)
+	}
+	// An empty release_date clears it.
+	if input.Status != nil {
+		movie.Status = *input.Status
+	}
+	if input.ReleaseDate != nil {
+		movie.ReleaseDate = *input.ReleaseDate
+	}
+	if input.Releases != nil {
+		movie.Releases = canonicalReleases(input.Releases)
 	}
 
-	// Pass the update movie a record to our new Update() method.
-	err = app.models.Movies.Update(movie)
+	return nil
+}
+
+func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract the movie ID from the URL.
+	id, err := app.readIDParam(r)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		app.notFoundResponse(w, r)
 		return
 	}
 
-	// Intercept any ErrEditConflict error and call the new editConflictResponse() helper
-	err = app.models.Movies.Update(movie)
+	// Move the movie to the trash, sending a 404 Not Found response to the client if there isn't a matching record.
+	err = app.models.Movies.Delete(id, app.contextGetUser(r))
 	if err != nil {
 		switch {
-		case errors.Is(err, data.ErrEditConflict):
-			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
 		}
 		return
 	}
 
-	// Write the updated movie record in a JSON response.s
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	// Return a 200 OK status code along with a success message.
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
-func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract the movie ID from the URL.
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
+// The canonicalTitles() helper returns a copy of a map of localized titles with the language tags in their canonical case, so
+// that "pt-br" and "pt-BR" are stored as the same language.
+func canonicalTitles(titles map[string]string) map[string]string {
+	if titles == nil {
+		return nil
+	}
+
+	canonical := make(map[string]string, len(titles))
+	for tag, title := range titles {
+		canonical[data.CanonicalLanguageTag(tag)] = title
+	}
+
+	return canonical
+}
+
+// The canonicalReleases() helper returns a copy of a map of per-country release dates with the country codes in uppercase.
+func canonicalReleases(releases map[string]string) map[string]string {
+	if releases == nil {
+		return nil
+	}
+
+	canonical := make(map[string]string, len(releases))
+	for country, date := range releases {
+		canonical[strings.ToUpper(country)] = date
+	}
+
+	return canonical
+}
+
+// The localizeMovies() helper localizes the title of each movie in a list, and returns the distinct languages it used.
+func localizeMovies(movies []*data.Movie, languages []string) []string {
+	used := []string{}
+
+	for _, movie := range movies {
+		if tag := movie.Localize(languages); tag != "" && !validator.In(tag, used...) {
+			used = append(used, tag)
+		}
+	}
+
+	return used
+}
+
+// The lookupMovieHandler finds a movie by its ID in another database, given as exactly one of the imdb, tmdb or wikidata
+// query string parameters, like /v1/movies/lookup?imdb=tt0111161.
+func (app *application) lookupMovieHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	var source, externalID string
+
+	for _, name := range data.ExternalIDSources {
+		if qs.Has(name) {
+			v.Check(source == "", name, "must not be given along with "+source)
+			source, externalID = name, qs.Get(name)
+		}
+	}
+
+	if source == "" {
+		v.AddError("lookup", "must give one of "+strings.Join(data.ExternalIDSources, ", "))
+	} else {
+		v.Check(data.ValidExternalID(source, externalID), source, "must be a valid "+source+" ID")
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	// Delete the movie from the database, sending a 404 Not Found response to the client if there isn't a matching record.
-	err = app.models.Movies.Delete(id)
+	// A movie in the trash still has its external IDs, so it's a 410 Gone, like fetching it by its own ID.
+	movie, err := app.models.Movies.GetByExternalID(source, externalID)
 	if err != nil {
 		switch {
+		case errors.Is(err, data.ErrRecordDeleted):
+			app.goneResponse(w, r)
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
 		default:
@@ -219,8 +431,72 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Return a 200 OK status code along with a success message.
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The upcomingMoviesHandler lists the films that haven't been released yet (or have a release date in the future), soonest first.
+// Films without a release date come last. It accepts the same search criteria as the movie list, including status, and can be
+// paged with page numbers or a cursor.
+func (app *application) upcomingMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.MovieSearch
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.MovieSearch = app.readMovieSearch(r, v)
+	input.MovieSearch.Upcoming = true
+
+	languages := app.readLanguages(r, v)
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "release_date")
+	input.Filters.SortSafeList = []string{"release_date", "title", "id", "-release_date", "-title", "-id"}
+
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = []byte(app.config.cursor.secret)
+
+	defaultLimit := 0
+	if input.Filters.Cursor != "" {
+		defaultLimit = 20
+	}
+	input.Filters.Limit = app.readInt(qs, "limit", defaultLimit, v)
+
+	data.ValidateMovieSearch(v, input.MovieSearch)
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// Resolve the genre names, now that we know there aren't too many of them.
+	err := app.resolveSearchGenres(v, &input.MovieSearch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.readMovies(r).GetAll(input.MovieSearch, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setContentLanguage(w, localizeMovies(movies, languages))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -229,9 +505,9 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
 	// To keep things consistent with our other handlers, we'll define an input struct to hold the expected values from the request query string.
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieSearch
 		data.Filters
+		Facets []string
 	}
 
 	// Initialize a new Validator instance
@@ -240,10 +516,11 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Call r.URL.Query() to get the url.Values map containing the query string data.
 	qs := r.URL.Query()
 
-	// Use our helpers to extract the title and genres query string values, falling back to defaults of an empty string and an empty slice respectively
-	// if they are not provided by the client
-	input.Title = app.readString(qs, "title", "")
-	input.Genres = app.readCSV(qs, "genres", []string{})
+	// Use our helpers to extract the title, genres and other search criteria from the query string.
+	input.MovieSearch = app.readMovieSearch(r, v)
+
+	// Read the languages to localize the titles in.
+	languages := app.readLanguages(r, v)
 
 	// Get the page and page_size query string values as integers.
 	// Notice that we set the default page value to 1 and default page_size to 20, and that we pass the validator instance as the final argument here
@@ -254,28 +531,234 @@ func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request
 	// Extract the sort query string value, falling back to "id" if it is not provided by the client
 	// (which will imply an ascending sort of movie ID).
 	// Read the sort query string value into the embedded struct
-	input.Filters.Sort = app.readString(qs, "sort", "id")
+	// When the client is running a full-text search with q, we default to showing the best matches first instead.
+	defaultSort := "id"
+	if input.Query != "" {
+		defaultSort = "relevance"
+	}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
 
 	// Add the supported sort values for this endpoint to the sort safe list
-	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.SortSafeList = []string{"id", "title", "year", "runtime", "rating", "release_date", "relevance", "-id", "-title", "-year", "-runtime", "-rating", "-release_date"}
+
+	// Clients can page with an opaque cursor and a limit instead of page and page_size.
+	// If a cursor is provided without a limit, we use the same default of 20 as page_size.
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = []byte(app.config.cursor.secret)
+
+	defaultLimit := 0
+	if input.Filters.Cursor != "" {
+		defaultLimit = 20
+	}
+	input.Filters.Limit = app.readInt(qs, "limit", defaultLimit, v)
+
+	// Read the list of facets the client would like counts for, if any.
+	input.Facets = app.readCSV(qs, "facets", []string{})
+
+	for _, facet := range input.Facets {
+		v.Check(validator.In(facet, data.FacetSafeList...), "facets", "must only contain genres, decade or runtime_bucket")
+	}
+	v.Check(validator.Unique(input.Facets), "facets", "must not contain duplicate values")
+
+	// Execute the validation checks on the search criteria and the Filters struct and send a response containing the errors if necessary.
+	data.ValidateMovieSearch(v, input.MovieSearch)
+	v.Check(input.Sort != "relevance" || input.Query != "", "sort", "relevance can only be used with the q parameter")
 
-	// Execute the validation checks on the Filters struct and send a response containing the errors if necessary.
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
+	// Resolve the genre names, now that we know there aren't too many of them.
+	err := app.resolveSearchGenres(v, &input.MovieSearch)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	// If facets were requested, start counting them in the background while we fetch the page of movies.
+	var facets <-chan data.Facets
+	var cancelFacets context.CancelFunc
+	if len(input.Facets) > 0 {
+		facets, cancelFacets = app.countFacets(r, input.MovieSearch, input.Facets)
+		// Called through a closure, so that it cancels whichever count is current by the time we return.
+		defer func() { cancelFacets() }()
+	}
+
 	// Accept the metadata struct as a return value.
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	// The list is read from a replica, unless the client has just made a change (see readMovies()).
+	movies, metadata, err := app.readMovies(r).GetAll(input.MovieSearch, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	// If the text search found nothing and the movies come from a similarity search instead, the facets being counted are for
+	// the wrong search, so we cancel that count and start again with the search that was used.
+	if metadata.Fuzzy && facets != nil {
+		search := input.MovieSearch
+		search.Fuzzy = true
+
+		cancelFacets()
+		facets, cancelFacets = app.countFacets(r, search, input.Facets)
+	}
+
+	// Localize each movie's title, and say which languages we used in the Content-Language header.
+	app.setContentLanguage(w, localizeMovies(movies, languages))
+
 	// Send a JSON response containing the movie data.
 	// Include the metadata in the response envelope.
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	env := envelope{"movies": movies, "metadata": metadata}
+
+	// Wait for the facets. If counting them took too long (or failed) we get nil, which is sent as "facets": null
+	// so the client can tell the difference between skipped facets and facets with no values.
+	if facets != nil {
+		env["facets"] = <-facets
+	}
+
+	err = app.writeJSON(w, http.StatusOK, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The countFacets() helper computes facet counts for a movie search in a background goroutine, and returns a channel which
+// receives the result, along with a function that cancels the count. The query is bounded by the facets timeout from our
+// config; if it takes longer than that, or fails for any other reason, the channel receives nil instead, because we'd rather
+// send the list without facets than hold it up or fail it.
+//
+// The facets come back in the same response as the list, but from their own query, run alongside GetAll() on another
+// connection. PostgreSQL's statement_timeout applies to a whole statement, so counting them in the same statement as the list
+// would mean a slow count failing the list as well, rather than just being left out.
+func (app *application) countFacets(r *http.Request, search data.MovieSearch, names []string) (<-chan data.Facets, context.CancelFunc) {
+	result := make(chan data.Facets, 1)
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.facets.timeout)
+
+	go func() {
+		// Our recoverPanic() middleware doesn't cover this goroutine, so we need to recover any panic here ourselves.
+		defer func() {
+			if err := recover(); err != nil {
+				app.logError(r, fmt.Errorf("%s", err))
+				result <- nil
+			}
+		}()
+
+		defer cancel()
+
+		facets, err := app.readMovies(r).Facets(ctx, search, names)
+		if err != nil {
+			// Running out of time is expected from time to time on large result sets, so only log other errors.
+			if ctx.Err() == nil {
+				app.logError(r, err)
+			}
+			result <- nil
+			return
+		}
+
+		result <- facets
+	}()
+
+	return result, cancel
+}
+
+// The suggestMoviesHandler returns a short list of titles similar to the q parameter, for type-ahead in search boxes.
+// It's called on every keystroke, so the response is kept small and can be cached by the client and any proxies for a minute.
+func (app *application) suggestMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	text := strings.TrimSpace(app.readString(qs, "q", ""))
+	limit := app.readInt(qs, "limit", 10, v)
+
+	// Trigrams need a few characters to work with, so there's no point searching on just one.
+	v.Check(utf8.RuneCountInString(text) >= 2, "q", "must be at least 2 characters long")
+	v.Check(len(text) <= 100, "q", "must not be more than 100 bytes long")
+	v.Check(limit > 0, "limit", "must be greater than zero")
+	v.Check(limit <= 20, "limit", "must be a maximum of 20")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	suggestions, err := app.models.Movies.Suggest(text, limit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=60")
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"suggestions": suggestions}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
+
+// The readMovieSearch() helper reads the search criteria shared by listMoviesHandler and exportMoviesHandler from the query string.
+// The title and genres values fall back to defaults of an empty string and an empty slice if they are not provided,
+// and the range bounds fall back to zero, which means that bound isn't applied.
+// The in_watchlist filter refers to the watchlist of whoever is making the request, so it needs the request as well as the query string.
+func (app *application) readMovieSearch(r *http.Request, v *validator.Validator) data.MovieSearch {
+	qs := r.URL.Query()
+
+	search := data.MovieSearch{
+		Query:         app.readString(qs, "q", ""),
+		Title:         app.readString(qs, "title", ""),
+		Genres:        app.readCSV(qs, "genres", []string{}),
+		GenresAny:     app.readCSV(qs, "genres_any", []string{}),
+		GenresExclude: app.readCSV(qs, "genres_exclude", []string{}),
+		YearMin:       app.readInt(qs, "year_min", 0, v),
+		YearMax:       app.readInt(qs, "year_max", 0, v),
+		RuntimeMin:    app.readInt(qs, "runtime_min", 0, v),
+		RuntimeMax:    app.readInt(qs, "runtime_max", 0, v),
+		CreatedAfter:  app.readTime(qs, "created_after", time.Time{}, v),
+		CreatedBefore: app.readTime(qs, "created_before", time.Time{}, v),
+		PersonID:      int64(app.readInt(qs, "person", 0, v)),
+		PersonRole:    app.readString(qs, "person_role", ""),
+		Statuses:      app.readCSV(qs, "status", []string{}),
+	}
+
+	if qs.Has("in_watchlist") {
+		search.InWatchlist = app.readBool(qs, "in_watchlist", false, v)
+
+		user := app.contextGetUser(r)
+		if user.IsAnonymous() {
+			v.AddError("in_watchlist", "can only be used by an authenticated user")
+		}
+		search.WatchlistUser = user.ID
+	}
+
+	return search
+}
+
+// The reindexMovies() method runs in the background when the server starts, and moves every movie that was indexed with a
+// different text search configuration onto the current one (see MovieModel.Reindex()).
+func (app *application) reindexMovies() {
+	// Panics in this goroutine aren't caught by the recoverPanic() middleware, so we recover them here and log them instead.
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	reindexed, err := app.models.Movies.Reindex()
+	if err != nil {
+		app.logger.PrintError(err, nil)
+	}
+
+	if reindexed > 0 {
+		app.logger.PrintInfo("reindexed movies for full-text search", map[string]string{
+			"count":  strconv.FormatInt(reindexed, 10),
+			"config": app.models.Movies.SearchConfig,
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonpatch"
+	"net/http"
+	"strings"
+)
+
+// The movieDocument struct is the JSON document that merge patches and JSON Patches to a movie are applied to. It holds the
+// fields that can be changed, and unlike the movie's usual JSON it always includes every one of them, even when they're empty,
+// so that a patch can add a genre to a movie that doesn't have any yet. The version is there so that a test operation can
+// check it, but changing it has no effect.
+type movieDocument struct {
+	Title       string            `json:"title"`
+	Year        int32             `json:"year"`
+	Runtime     data.Runtime      `json:"runtime"`
+	Genres      []string          `json:"genres"`
+	ExternalIDs map[string]string `json:"external_ids"`
+	Titles      map[string]string `json:"titles"`
+	Status      string            `json:"status"`
+	ReleaseDate string            `json:"release_date"`
+	Releases    map[string]string `json:"releases"`
+	Version     int32             `json:"version"`
+}
+
+// The readMoviePatch() helper reads a JSON Merge Patch or JSON Patch from the request body, as given by mediaType, and applies
+// it to the movie. With a merge patch, setting a field to null clears it.
+func (app *application) readMoviePatch(w http.ResponseWriter, r *http.Request, movie *data.Movie, mediaType string) error {
+	current := movieDocument{
+		Title:       movie.Title,
+		Year:        movie.Year,
+		Runtime:     movie.Runtime,
+		Genres:      movie.Genres,
+		ExternalIDs: movie.ExternalIDs,
+		Titles:      movie.Titles,
+		Status:      movie.Status,
+		ReleaseDate: movie.ReleaseDate,
+		Releases:    movie.Releases,
+		Version:     movie.Version,
+	}
+
+	if current.Genres == nil {
+		current.Genres = []string{}
+	}
+	if current.ExternalIDs == nil {
+		current.ExternalIDs = map[string]string{}
+	}
+	if current.Titles == nil {
+		current.Titles = map[string]string{}
+	}
+	if current.Releases == nil {
+		current.Releases = map[string]string{}
+	}
+
+	// Turn the document into the generic form that the patch functions work on.
+	js, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	var doc interface{}
+
+	err = json.Unmarshal(js, &doc)
+	if err != nil {
+		return err
+	}
+
+	if mediaType == "application/json-patch+json" {
+		var ops []jsonpatch.Operation
+
+		err = app.readJSON(w, r, &ops)
+		if err != nil {
+			return err
+		}
+
+		doc, err = jsonpatch.Apply(doc, ops)
+		if err != nil {
+			return err
+		}
+	} else {
+		var patch interface{}
+
+		err = app.readJSON(w, r, &patch)
+		if err != nil {
+			return err
+		}
+
+		doc = jsonpatch.MergePatch(doc, patch)
+	}
+
+	// Then decode the patched document back into a movieDocument. Fields that a merge patch removed are left with their zero value.
+	js, err = json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	var patched movieDocument
+
+	err = decodePatchedDocument(js, &patched)
+	if err != nil {
+		return err
+	}
+
+	movie.Title = patched.Title
+	movie.Year = patched.Year
+	movie.Runtime = patched.Runtime
+	movie.Genres = patched.Genres
+	movie.ExternalIDs = patched.ExternalIDs
+	movie.Titles = canonicalTitles(patched.Titles)
+	movie.Status = patched.Status
+	movie.ReleaseDate = patched.ReleaseDate
+	movie.Releases = canonicalReleases(patched.Releases)
+
+	return nil
+}
+
+// The decodePatchedDocument() helper decodes a patched document, turning the errors for a document that the patch has broken
+// into plain-english messages, like readJSON() does for request bodies.
+func decodePatchedDocument(js []byte, dst *movieDocument) error {
+	dec := json.NewDecoder(bytes.NewReader(js))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var unmarshalTypeError *json.UnmarshalTypeError
+
+		switch {
+		case errors.As(err, &unmarshalTypeError) && unmarshalTypeError.Field != "":
+			return fmt.Errorf("%w: the patch gives field %q an incorrect JSON type", jsonpatch.ErrInvalidOperation, unmarshalTypeError.Field)
+		case errors.As(err, &unmarshalTypeError):
+			return fmt.Errorf("%w: the patched movie must be a JSON object", jsonpatch.ErrInvalidOperation)
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			fieldName := strings.TrimPrefix(err.Error(), "json: unknown field ")
+			return fmt.Errorf("%w: the patch adds unknown key %s", jsonpatch.ErrInvalidOperation, fieldName)
+		case errors.Is(err, data.ErrInvalidRuntimeFormat):
+			return fmt.Errorf("%w: %s", jsonpatch.ErrInvalidOperation, err)
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"greenlight/internal/blob"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	// Register the GIF and PNG decoders with the image package, so image.Decode() can read them. JPEG is registered by the import above.
+	_ "image/gif"
+	_ "image/png"
+
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/image/draw"
+)
+
+// Poster uploads are far too big for readJSON()'s 1MB limit, so they're sent as the raw request body (or as the "poster"
+// file in a multipart form) instead, and limited to 10MB.
+const maxPosterBytes = 10 << 20
+
+// Posters must be at least 100 pixels on each side. The upper limits stop a small, highly compressed file from decoding into
+// an enormous image that would use up all of our memory.
+const (
+	minPosterDimension = 100
+	maxPosterWidth     = 4000
+	maxPosterHeight    = 6000
+)
+
+// The image types we accept, keyed by the content type http.DetectContentType() sniffs from the data, with the file extension
+// we store them under.
+var posterTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+}
+
+// The thumbnail sizes we generate for every poster, by width. Images narrower than a size aren't scaled up.
+var posterSizes = []struct {
+	name  string
+	width int
+}{
+	{"small", 92},
+	{"medium", 342},
+	{"large", 780},
+}
+
+// The uploadPosterHandler sets a movie's poster. The image's type is sniffed from its content rather than trusted from the
+// Content-Type header, and once it's been checked we store the original along with a JPEG thumbnail in each of the posterSizes.
+// Any previous poster is replaced, and its blobs are garbage collected.
+func (app *application) uploadPosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	// Check that the movie exists before reading what could be a large upload.
+	_, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	body, err := app.readUpload(w, r, "poster", maxPosterBytes)
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.payloadTooLargeResponse(w, r, maxBytesError.Limit)
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	contentType := http.DetectContentType(body)
+
+	ext, ok := posterTypes[contentType]
+	if !ok {
+		app.unsupportedMediaTypeResponse(w, r, "the poster must be a JPEG, PNG or GIF image")
+		return
+	}
+
+	// Read just the image's header to check its dimensions before decoding the whole thing.
+	config, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"poster": "must be a valid image"})
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(config.Width >= minPosterDimension && config.Height >= minPosterDimension, "poster", fmt.Sprintf("must be at least %d pixels wide and high", minPosterDimension))
+	v.Check(config.Width <= maxPosterWidth && config.Height <= maxPosterHeight, "poster", fmt.Sprintf("must not be more than %d pixels wide or %d pixels high", maxPosterWidth, maxPosterHeight))
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		app.failedValidationResponse(w, r, map[string]string{"poster": "must be a valid image"})
+		return
+	}
+
+	// Every upload gets its own random key prefix, so a poster's blobs are never overwritten, and their URLs can be cached forever.
+	prefix, err := posterKeyPrefix(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	type version struct {
+		key         string
+		data        []byte
+		contentType string
+	}
+
+	versions := map[string]version{
+		"original": {prefix + "original" + ext, body, contentType},
+	}
+
+	for _, size := range posterSizes {
+		thumbnail, err := encodeThumbnail(img, size.width)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		versions[size.name] = version{prefix + size.name + ".jpg", thumbnail, "image/jpeg"}
+	}
+
+	keys := []string{}
+	for _, version := range versions {
+		keys = append(keys, version.key)
+	}
+
+	// Record the keys as orphaned before writing the blobs, so they'll be cleaned up if anything goes wrong from here on.
+	err = app.models.Posters.Reserve(keys)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	poster := &data.Poster{
+		Width:  config.Width,
+		Height: config.Height,
+		URLs:   map[string]string{},
+	}
+
+	for name, version := range versions {
+		err = app.blobs.Put(r.Context(), version.key, version.data, version.contentType)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		poster.URLs[name] = app.blobs.URL(version.key)
+	}
+
+	err = app.models.Posters.Set(id, poster, keys)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"poster": poster}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deletePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Posters.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "poster successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The serveBlobHandler sends a blob from the blob store. It's how clients download posters when they're kept in a LocalStore.
+// Blobs never change once written, so they can be cached for as long as the client likes.
+func (app *application) serveBlobHandler(w http.ResponseWriter, r *http.Request) {
+	// The *key catch-all parameter includes the leading slash.
+	key := strings.TrimPrefix(httprouter.ParamsFromContext(r.Context()).ByName("key"), "/")
+
+	rc, contentType, err := app.blobs.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, blob.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	// Once we've started sending the blob it's too late to send an error response, so all we can do is log the error.
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		app.logError(r, err)
+	}
+}
+
+// The readUpload() helper reads an uploaded file from the request body, which can either be the raw file itself, or a
+// multipart form with the file in the given field. The body is limited to maxBytes, and an *http.MaxBytesError is returned
+// if it's any bigger.
+func (app *application) readUpload(w http.ResponseWriter, r *http.Request, field string, maxBytes int64) ([]byte, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	var body []byte
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "multipart/form-data" {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil, fmt.Errorf("body must contain a %q file", field)
+				}
+				return nil, err
+			}
+
+			if part.FormName() == field {
+				body, err = io.ReadAll(part)
+				if err != nil {
+					return nil, err
+				}
+				break
+			}
+		}
+	} else {
+		var err error
+
+		body, err = io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(body) == 0 {
+		return nil, errors.New("body must not be empty")
+	}
+
+	return body, nil
+}
+
+// The posterKeyPrefix() function returns a new, random prefix for the keys of a poster's blobs, like "posters/42/1f9c0b7e3a5d2468/".
+func posterKeyPrefix(movieID int64) (string, error) {
+	random := make([]byte, 8)
+
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("posters/%d/%s/", movieID, hex.EncodeToString(random)), nil
+}
+
+// The encodeThumbnail() function scales an image down to the given width, keeping its aspect ratio, and encodes it as a JPEG.
+// JPEG has no transparency, so transparent parts of PNGs and GIFs are filled in with white.
+func encodeThumbnail(img image.Image, width int) ([]byte, error) {
+	bounds := img.Bounds()
+
+	if bounds.Dx() < width {
+		width = bounds.Dx()
+	}
+
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(thumbnail, thumbnail.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.CatmullRom.Scale(thumbnail, thumbnail.Bounds(), img, bounds, draw.Over, nil)
+
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, thumbnail, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// The collectOrphanedBlobs() method runs in the background for as long as the server does, and deletes orphaned blobs (like
+// those of replaced posters, or of deleted movies) from the blob store every interval.
+func (app *application) collectOrphanedBlobs(interval, grace time.Duration) {
+	// Panics in this goroutine aren't caught by the recoverPanic() middleware, so we recover them here and log them instead.
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		err := app.deleteOrphanedBlobs(grace)
+		if err != nil {
+			app.logger.PrintError(err, nil)
+		}
+	}
+}
+
+// The deleteOrphanedBlobs() method deletes every blob that's been orphaned for longer than the grace period, in batches.
+// A key is only taken off the orphaned list once its blob has been deleted, so a blob that fails to delete is tried again next time.
+func (app *application) deleteOrphanedBlobs(grace time.Duration) error {
+	const batchSize = 100
+
+	for {
+		keys, err := app.models.Posters.Orphans(grace, batchSize)
+		if err != nil {
+			return err
+		}
+
+		deleted := []string{}
+
+		for _, key := range keys {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			err := app.blobs.Delete(ctx, key)
+			cancel()
+
+			// Keys that aren't valid can never have been written, so there's nothing to delete.
+			if err != nil && !errors.Is(err, blob.ErrInvalidKey) {
+				app.logger.PrintError(err, map[string]string{"blob_key": key})
+				continue
+			}
+
+			deleted = append(deleted, key)
+		}
+
+		if len(deleted) > 0 {
+			err = app.models.Posters.ForgetOrphans(deleted)
+			if err != nil {
+				return err
+			}
+
+			app.logger.PrintInfo("deleted orphaned blobs", map[string]string{"count": fmt.Sprint(len(deleted))})
+		}
+
+		// Stop when we've run out of orphans, or when none of this batch could be deleted, so we don't keep retrying them in a loop.
+		if len(keys) < batchSize || len(deleted) == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"greenlight/internal/data"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The writeTracker type remembers when each client last made a change. For a short window afterwards, that client's reads go
+// to the primary rather than a replica, so that they see their own change even if the replicas haven't caught up with it yet.
+type writeTracker struct {
+	mu      sync.Mutex
+	clients map[string]time.Time
+	window  time.Duration
+}
+
+// The newWriteTracker() function returns a writeTracker, and starts a background goroutine which forgets clients whose window
+// has passed once a minute, so that the map doesn't keep growing.
+func newWriteTracker(window time.Duration) *writeTracker {
+	wt := &writeTracker{
+		clients: make(map[string]time.Time),
+		window:  window,
+	}
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			wt.mu.Lock()
+
+			for client, wrote := range wt.clients {
+				if time.Since(wrote) > wt.window {
+					delete(wt.clients, client)
+				}
+			}
+
+			wt.mu.Unlock()
+		}
+	}()
+
+	return wt
+}
+
+// The record() method notes that the client has just made a change.
+func (wt *writeTracker) record(client string) {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	wt.clients[client] = time.Now()
+}
+
+// The recent() method reports whether the client made a change within the window.
+func (wt *writeTracker) recent(client string) bool {
+	wt.mu.Lock()
+	defer wt.mu.Unlock()
+
+	wrote, ok := wt.clients[client]
+
+	return ok && time.Since(wrote) <= wt.window
+}
+
+// The readClient() method identifies the client for read-your-writes: the user, if the request is authenticated, or the IP
+// address it came from otherwise.
+func (app *application) readClient(r *http.Request) string {
+	user := app.contextGetUser(r)
+	if !user.IsAnonymous() {
+		return "user:" + strconv.FormatInt(user.ID, 10)
+	}
+
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	return "ip:" + ip
+}
+
+// The trackWrites() middleware records every request that might change something (anything but GET, HEAD and OPTIONS) in the
+// writeTracker. It's recorded before the request is handled, so the client's reads go to the primary while the change is being
+// made, and again afterwards, so the window starts from when the change was finished.
+func (app *application) trackWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.writes == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		client := app.readClient(r)
+
+		app.writes.record(client)
+		defer app.writes.record(client)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// The readMovies() method returns the movie model that the movie list and show handlers should read from: one backed by a
+// read replica, unless the client has made a change recently, in which case it's the primary.
+func (app *application) readMovies(r *http.Request) data.MovieModel {
+	if app.writes != nil && app.writes.recent(app.readClient(r)) {
+		return app.models.Movies
+	}
+
+	return app.models.Movies.Replica()
+}
+
+// The monitorReplicas() method runs in the background for as long as the server does, and checks the read replicas every
+// interval, taking unhealthy ones out of rotation until they recover. Each replica going out of or back into rotation is logged.
+func (app *application) monitorReplicas(interval, maxLag time.Duration) {
+	// Panics in this goroutine aren't caught by the recoverPanic() middleware, so we recover them here and log them instead.
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.PrintError(fmt.Errorf("%s", err), nil)
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		app.checkReplicas(maxLag)
+	}
+}
+
+// The checkReplicas() method checks the read replicas once, and logs any that have gone out of or back into rotation.
+func (app *application) checkReplicas(maxLag time.Duration) {
+	for _, status := range app.models.Movies.Replicas.Check(maxLag) {
+		if !status.Changed {
+			continue
+		}
+
+		properties := map[string]string{"replica": strconv.Itoa(status.Replica)}
+
+		if status.Healthy {
+			app.logger.PrintInfo("read replica in rotation", properties)
+		} else {
+			app.logger.PrintError(fmt.Errorf("read replica out of rotation: %w", status.Err), properties)
+		}
+	}
+}
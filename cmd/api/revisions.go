@@ -0,0 +1,250 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"math"
+	"net/http"
+	"strconv"
+)
+
+// The readVersionParam() helper reads the "version" URL parameter, in the same way as readIDParam().
+func (app *application) readVersionParam(r *http.Request) (int32, error) {
+	version, err := app.readNamedIDParam(r, "version")
+	if err != nil || version > math.MaxInt32 {
+		return 0, errors.New("invalid version parameter")
+	}
+
+	return int32(version), nil
+}
+
+// The listMovieHistoryHandler returns a page of a movie's revisions, newest first by default. Use showMovieRevisionHandler
+// to see the movie as it was at one of them.
+func (app *application) listMovieHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-version")
+	input.Filters.SortSafeList = []string{"version", "-version"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions, metadata, err := app.models.Movies.History(id, input.Filters)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"history": revisions, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The showMovieRevisionHandler returns a single revision of a movie, including the movie as it was at that version.
+func (app *application) showMovieRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	revision, err := app.models.Movies.GetRevision(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"revision": revision}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The revertMovieHandler puts a movie back the way it was at an earlier version, by updating it with the details from that
+// version's snapshot. The revert becomes the movie's newest version. As with any other update, it fails with an edit conflict if
+// the movie changes in the meantime, and the client can send the version it expects to be reverting from in the
+// X-Expected-Version header to make sure it isn't overwriting a change it hasn't seen.
+func (app *application) revertMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	version, err := app.readVersionParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordDeleted):
+			app.goneResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if expected := r.Header.Get("X-Expected-Version"); expected != "" {
+		if strconv.FormatInt(int64(movie.Version), 10) != expected {
+			app.editConflictResponse(w, r)
+			return
+		}
+	}
+
+	revision, err := app.models.Movies.GetRevision(id, version)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Take the old details, but keep the current version number, which is what Revert() checks for conflicts against.
+	reverted := revision.Movie
+	reverted.Version = movie.Version
+
+	// The old details have to be valid now, too. A genre may have been deleted since, or a release date that was in the future
+	// may no longer be allowed for the movie's status.
+	v := validator.New()
+
+	err = app.resolveGenres(v, reverted)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if data.ValidateMovie(v, reverted); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.models.Movies.Revert(reverted, app.contextGetUser(r), version)
+	if err != nil {
+		var conflictErr *data.ExternalIDConflictError
+
+		switch {
+		case errors.As(err, &conflictErr):
+			app.externalIDConflictResponse(w, r, conflictErr)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrUnknownGenre):
+			v.AddError("genres", "contains unknown genres")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the movie again, so the response includes the parts of it that a revert doesn't touch, like its poster and rating.
+	movie, err = app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The diffMovieHandler compares two versions of a movie, given by the from and to query string parameters, like
+// /v1/movies/1/diff?from=3&to=7. By default it responds with the changes field by field, or with format=json-patch, with the
+// JSON Patch (RFC 6902) that turns the movie at the first version into the movie at the second.
+func (app *application) diffMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	from := app.readInt(qs, "from", 0, v)
+	to := app.readInt(qs, "to", 0, v)
+	format := app.readString(qs, "format", "diff")
+
+	v.Check(from >= 1 && from <= math.MaxInt32, "from", "must be a version number")
+	v.Check(to >= 1 && to <= math.MaxInt32, "to", "must be a version number")
+	v.Check(validator.In(format, "diff", "json-patch"), "format", "must be diff or json-patch")
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	revisions := make([]*data.MovieRevision, 2)
+
+	for i, version := range []int{from, to} {
+		revisions[i], err = app.models.Movies.GetRevision(id, int32(version))
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+	}
+
+	if format == "json-patch" {
+		err = app.writeJSONPatch(w, http.StatusOK, data.MoviePatch(revisions[0].Movie, revisions[1].Movie))
+	} else {
+		err = app.writeJSON(w, http.StatusOK, envelope{"diff": data.DiffMovies(revisions[0].Movie, revisions[1].Movie)}, nil)
+	}
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
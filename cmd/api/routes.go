@@ -22,13 +22,110 @@ func (app *application) routes() http.Handler {
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	// Add the route for the GET /v1/movies endpoint
 	router.HandlerFunc(http.MethodGet, "/v1/movies", app.listMoviesHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.createMovieHandler)
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.showMovieHandler)
+	// Every change to a movie is recorded in its history along with the user who made it, so changing the catalogue requires an
+	// authenticated user.
+	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requireAuthenticatedUser(app.createMovieHandler))
+	// The events, export, lookup, suggest, trash and upcoming endpoints share their position in the path with the :id wildcard, so they're dispatched by routeByID() (see below).
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.routeByID(map[string]http.HandlerFunc{
+		"events":   app.movieEventsHandler,
+		"export":   app.exportMoviesHandler,
+		"lookup":   app.lookupMovieHandler,
+		"suggest":  app.suggestMoviesHandler,
+		"trash":    app.listTrashHandler,
+		"upcoming": app.upcomingMoviesHandler,
+	}, app.showMovieHandler))
 	// PUT is meant to replace the entire resource. PATCH is partial
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.updateMovieHandler)
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.deleteMovieHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requireAuthenticatedUser(app.updateMovieHandler))
+	// Deleting a movie moves it to the trash, and DELETE /v1/movies/trash purges the movies that have been there long enough.
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.routeByID(map[string]http.HandlerFunc{
+		"trash": app.requireAdmin(app.purgeTrashHandler),
+	}, app.requireAuthenticatedUser(app.deleteMovieHandler)))
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/restore", app.requireAuthenticatedUser(app.restoreMovieHandler))
+	// Every change to a movie is kept as a revision. Any two of them can be compared, and the movie can be reverted to any of them.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/history", app.listMovieHistoryHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/history/:version", app.showMovieRevisionHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/diff", app.diffMovieHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/revert/:version", app.requireAuthenticatedUser(app.revertMovieHandler))
+	// Posters are uploaded as the raw image, or in a multipart form, rather than as JSON.
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/poster", app.requireAuthenticatedUser(app.uploadPosterHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/poster", app.requireAuthenticatedUser(app.deletePosterHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/credits", app.listMovieCreditsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/credits", app.requireAuthenticatedUser(app.createMovieCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/credits/:credit_id", app.requireAuthenticatedUser(app.deleteMovieCreditHandler))
+	// Anyone can read reviews, but writing them requires an authenticated user. Each user has at most one review per movie,
+	// so PUT and DELETE act on the current user's review and don't need a review ID.
+	router.HandlerFunc(http.MethodGet, "/v1/movies/:id/reviews", app.listMovieReviewsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/movies/:id/reviews", app.requireAuthenticatedUser(app.createMovieReviewHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/movies/:id/reviews", app.requireAuthenticatedUser(app.updateMovieReviewHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id/reviews", app.requireAuthenticatedUser(app.deleteMovieReviewHandler))
+
+	// Genres are shared by every movie, and renaming or deleting one changes all of them, so only admins can manage them.
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.listGenresHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/genres", app.requireAdmin(app.createGenreHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/genres/:id", app.showGenreHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/genres/:id", app.requireAdmin(app.updateGenreHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/genres/:id", app.requireAdmin(app.deleteGenreHandler))
+
+	// Anyone can browse public collections, but only authenticated users can create them, and only a collection's owner can change it.
+	router.HandlerFunc(http.MethodGet, "/v1/collections", app.listCollectionsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/collections", app.requireAuthenticatedUser(app.createCollectionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/collections/:id", app.showCollectionHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id", app.requireAuthenticatedUser(app.updateCollectionHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id", app.requireAuthenticatedUser(app.deleteCollectionHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/collections/:id/movies", app.requireAuthenticatedUser(app.addCollectionMovieHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/collections/:id/movies/:movie_id", app.requireAuthenticatedUser(app.moveCollectionMovieHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/collections/:id/movies/:movie_id", app.requireAuthenticatedUser(app.removeCollectionMovieHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/shared/collections/:token", app.showSharedCollectionHandler)
+
+	// Like the movies they're credited on, anyone can look people up, but changing them requires an authenticated user.
+	router.HandlerFunc(http.MethodGet, "/v1/people", app.listPeopleHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/people", app.requireAuthenticatedUser(app.createPersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id", app.showPersonHandler)
+	router.HandlerFunc(http.MethodPatch, "/v1/people/:id", app.requireAuthenticatedUser(app.updatePersonHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/people/:id", app.requireAuthenticatedUser(app.deletePersonHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/people/:id/credits", app.listPersonCreditsHandler)
+
+	// The current user's watchlist and watched log. The :id is the movie ID for watchlist items, and the entry ID in the watched log.
+	router.HandlerFunc(http.MethodGet, "/v1/me/watchlist", app.requireAuthenticatedUser(app.listWatchlistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/me/watchlist", app.requireAuthenticatedUser(app.addToWatchlistHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/me/watchlist/:id", app.requireAuthenticatedUser(app.moveWatchlistItemHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/me/watchlist/:id", app.requireAuthenticatedUser(app.removeFromWatchlistHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/me/watched", app.requireAuthenticatedUser(app.listWatchedHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/me/watched", app.requireAuthenticatedUser(app.addWatchedHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/me/watched/:id", app.requireAuthenticatedUser(app.updateWatchedHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/me/watched/:id", app.requireAuthenticatedUser(app.deleteWatchedHandler))
+
+	// Serve the files in the blob store, when it's a LocalStore. The *key parameter matches the rest of the path, slashes and all.
+	router.HandlerFunc(http.MethodGet, "/v1/blobs/*key", app.serveBlobHandler)
+
+	// Expose the application metrics, like the movie cache's hit and miss counters, to admins.
+	router.HandlerFunc(http.MethodGet, "/debug/vars", app.requireAdmin(app.metricsHandler))
+
+	// The batch endpoint runs a list of requests through this same router.
+	router.HandlerFunc(http.MethodPost, "/v1/batch", app.batchHandler)
+
+	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
 
 	// Return the httprouter instance
 	// Wrap the router with the panic recovery middleware
-	return app.recoverPanic(router)
+	// Use the authenticate() middleware on all requests, so every handler can find out who the user is.
+	// The trackWrites() middleware comes after it, since it needs to know who the user is too.
+	return app.recoverPanic(app.authenticate(app.trackWrites(router)))
+}
+
+// httprouter doesn't allow a fixed path segment, like the "export" in /v1/movies/export, to share a position with a
+// wildcard like :id. So instead we register the wildcard route once, and use routeByID() to send the request to the handler
+// named by the value of the "id" parameter if there is one, or to the fallback handler (which will parse it as an ID) otherwise.
+func (app *application) routeByID(named map[string]http.HandlerFunc, fallback http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+
+		if handler, ok := named[params.ByName("id")]; ok {
+			handler(w, r)
+			return
+		}
+
+		fallback(w, r)
+	}
 }
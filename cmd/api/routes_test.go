@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"greenlight/internal/data"
+	"greenlight/internal/jsonlog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// TestWritesRequireAuthentication checks that the routes which change the catalogue turn anonymous requests away before they
+// reach the database, so every change has a user to record it against.
+func TestWritesRequireAuthentication(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/v1/movies"},
+		{http.MethodPatch, "/v1/movies/1"},
+		{http.MethodDelete, "/v1/movies/1"},
+		{http.MethodPost, "/v1/movies/1/restore"},
+		{http.MethodPost, "/v1/movies/1/revert/2"},
+		{http.MethodPut, "/v1/movies/1/poster"},
+		{http.MethodDelete, "/v1/movies/1/poster"},
+		{http.MethodPost, "/v1/movies/1/credits"},
+		{http.MethodDelete, "/v1/movies/1/credits/1"},
+		{http.MethodPost, "/v1/genres"},
+		{http.MethodPatch, "/v1/genres/1"},
+		{http.MethodDelete, "/v1/genres/1"},
+		{http.MethodPost, "/v1/people"},
+		{http.MethodPatch, "/v1/people/1"},
+		{http.MethodDelete, "/v1/people/1"},
+	}
+
+	for _, tt := range tests {
+		rr := httptest.NewRecorder()
+		app.routes().ServeHTTP(rr, httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}")))
+
+		if rr.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s: got status %d; want %d", tt.method, tt.path, rr.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+// TestCascadingPersonDeleteRequiresAdmin checks that a user who isn't an admin can't delete a person along with their credits.
+func TestCascadingPersonDeleteRequiresAdmin(t *testing.T) {
+	app := &application{logger: jsonlog.New(io.Discard, jsonlog.LevelInfo)}
+
+	r := httptest.NewRequest(http.MethodDelete, "/v1/people/1?cascade=true", nil)
+	r = r.WithContext(context.WithValue(r.Context(), httprouter.ParamsKey, httprouter.Params{{Key: "id", Value: "1"}}))
+	r = app.contextSetUser(r, &data.User{ID: 1})
+
+	rr := httptest.NewRecorder()
+	app.deletePersonHandler(rr, r)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("got status %d; want %d", rr.Code, http.StatusForbidden)
+	}
+}
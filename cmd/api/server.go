@@ -21,6 +21,12 @@ func (app *application) serve() error {
 		WriteTimeout: 30 * time.Second,
 	}
 
+	// Streaming responses, like the movie event stream, would keep going until the shutdown timed out, so when the shutdown
+	// starts we close the change feed, which ends them.
+	if app.feed != nil {
+		srv.RegisterOnShutdown(app.feed.Close)
+	}
+
 	// Create a shutdownError channel.
 	// We will use this to receive any errors returned
 	// by the graceful Shutdown() function
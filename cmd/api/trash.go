@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"greenlight/internal/data"
+	"greenlight/internal/validator"
+	"net/http"
+	"strconv"
+)
+
+// The listTrashHandler returns the movies that have been deleted but not purged yet, most recently deleted first.
+func (app *application) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "-deleted_at")
+	input.Filters.SortSafeList = []string{"id", "title", "year", "deleted_at", "-id", "-title", "-year", "-deleted_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies, metadata, err := app.models.Movies.Trash(input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The restoreMovieHandler takes a movie back out of the trash, and responds with the restored movie.
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.Movies.Restore(id, app.contextGetUser(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Fetch the movie again so the response includes everything about it, including its new version number. If it was
+	// deleted again in the meantime, Get() will say so.
+	movie, err := app.models.Movies.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordDeleted):
+			app.goneResponse(w, r)
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// The purgeTrashHandler deletes the movies that have been in the trash for longer than the retention period for good, and
+// responds with how many were purged. Only admins can use it.
+func (app *application) purgeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	purged, err := app.models.Movies.Purge(app.config.trash.retention)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.logger.PrintInfo("purged deleted movies", map[string]string{
+		"purged":    strconv.Itoa(purged),
+		"retention": app.config.trash.retention.String(),
+	})
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"purged": purged}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -5,4 +5,6 @@ go 1.23.3
 require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.31.0
+	golang.org/x/image v0.23.0
 )
@@ -0,0 +1,49 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// Define the errors a Store can return: ErrNotFound when there's no blob with the requested key, and ErrInvalidKey for keys
+// that fail validKey().
+var (
+	ErrNotFound   = errors.New("blob not found")
+	ErrInvalidKey = errors.New("invalid blob key")
+)
+
+// The Store interface is implemented by the places we can keep uploaded files, like posters. Blobs are identified by a key,
+// which is a slash-separated path like "posters/42/abc123/small.jpg". Keys are chosen by the caller and never reused, so
+// a blob never changes once it has been written, and its URL can be cached forever.
+type Store interface {
+	// Put stores the data under the given key, replacing anything that was already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get opens the blob with the given key, and returns its content type along with the data. The caller must close the reader.
+	Get(ctx context.Context, key string) (io.ReadCloser, string, error)
+	// Delete removes the blob with the given key. Deleting a blob that doesn't exist is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns the address clients can download the blob from.
+	URL(key string) string
+}
+
+// The validKey() function reports whether a key is safe to use as a path: it must be relative, and can't contain empty,
+// "." or ".." segments, so it can never point outside the store.
+func validKey(key string) bool {
+	if key == "" || key[0] == '/' || key[len(key)-1] == '/' {
+		return false
+	}
+
+	start := 0
+	for i := 0; i <= len(key); i++ {
+		if i == len(key) || key[i] == '/' {
+			segment := key[start:i]
+			if segment == "" || segment == "." || segment == ".." {
+				return false
+			}
+			start = i + 1
+		}
+	}
+
+	return true
+}
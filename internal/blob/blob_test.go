@@ -0,0 +1,218 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestValidKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		valid bool
+	}{
+		{"posters/42/abc123/small.jpg", true},
+		{"poster.jpg", true},
+		{"posters/..hidden/x.jpg", true},
+		{"", false},
+		{"/posters/42.jpg", false},
+		{"posters/42/", false},
+		{"posters//42.jpg", false},
+		{"posters/./42.jpg", false},
+		{"posters/../42.jpg", false},
+		{"..", false},
+		{".", false},
+		{"../etc/passwd", false},
+	}
+
+	for _, tt := range tests {
+		if got := validKey(tt.key); got != tt.valid {
+			t.Errorf("validKey(%q) = %t; want %t", tt.key, got, tt.valid)
+		}
+	}
+}
+
+// The testStore() function runs a Store through putting, getting and deleting a blob, and checks that it refuses invalid keys.
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	key := "posters/42/abc123/small.jpg"
+	data := []byte("not really a jpeg")
+
+	_, _, err := store.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() before Put() returned %v; want ErrNotFound", err)
+	}
+
+	err = store.Put(ctx, key, data, "image/jpeg")
+	if err != nil {
+		t.Fatalf("Put() returned %v", err)
+	}
+
+	r, contentType, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() returned %v", err)
+	}
+
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, data) {
+		t.Errorf("Get() returned %q; want %q", got, data)
+	}
+
+	if contentType != "image/jpeg" {
+		t.Errorf("Get() returned content type %q; want %q", contentType, "image/jpeg")
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("Delete() returned %v", err)
+	}
+
+	_, _, err = store.Get(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() after Delete() returned %v; want ErrNotFound", err)
+	}
+
+	// Deleting a blob that doesn't exist isn't an error.
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Errorf("second Delete() returned %v; want nil", err)
+	}
+
+	for _, key := range []string{"", "/abs.jpg", "../escape.jpg", "posters/../../escape.jpg"} {
+		if err := store.Put(ctx, key, data, "image/jpeg"); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put(%q) returned %v; want ErrInvalidKey", key, err)
+		}
+
+		if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("Get(%q) returned %v; want ErrNotFound", key, err)
+		}
+
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete(%q) returned %v; want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestLocalStore(t *testing.T) {
+	testStore(t, &LocalStore{Dir: t.TempDir(), BaseURL: "/v1/blobs"})
+}
+
+// The fakeS3 type is a stand-in for S3, which keeps objects in memory. It checks that every request is for the right bucket, is
+// signed, and has a payload hash that matches its body.
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	mu      sync.Mutex
+	objects map[string]fakeObject
+}
+
+type fakeObject struct {
+	data        []byte
+	contentType string
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		s.t.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hash := sha256.Sum256(body)
+	if got := r.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(hash[:]) {
+		s.t.Errorf("%s %s: got payload hash %q; want the body's", r.Method, r.URL.Path, got)
+	}
+
+	if auth := r.Header.Get("Authorization"); !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=key/") {
+		s.t.Errorf("%s %s: got Authorization %q; want a signature", r.Method, r.URL.Path, auth)
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+s.bucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		s.objects[key] = fakeObject{data: body, contentType: r.Header.Get("Content-Type")}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		object, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+			return
+		}
+		w.Header().Set("Content-Type", object.contentType)
+		w.Write(object.data)
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func TestS3Store(t *testing.T) {
+	srv := httptest.NewServer(&fakeS3{t: t, bucket: "greenlight", objects: make(map[string]fakeObject)})
+	defer srv.Close()
+
+	testStore(t, &S3Store{
+		Endpoint:  srv.URL,
+		Region:    "us-east-1",
+		Bucket:    "greenlight",
+		AccessKey: "key",
+		SecretKey: "secret",
+		Client:    srv.Client(),
+	})
+}
+
+func TestS3StoreError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>AccessDenied</Code></Error>")
+	}))
+	defer srv.Close()
+
+	store := &S3Store{Endpoint: srv.URL, Region: "us-east-1", Bucket: "greenlight", Client: srv.Client()}
+
+	err := store.Put(context.Background(), "posters/1.jpg", []byte("x"), "image/jpeg")
+	if err == nil || !strings.Contains(err.Error(), "AccessDenied") {
+		t.Errorf("Put() returned %v; want an error including S3's error code", err)
+	}
+}
+
+func TestS3StoreURL(t *testing.T) {
+	tests := []struct {
+		store *S3Store
+		key   string
+		want  string
+	}{
+		{&S3Store{Endpoint: "http://localhost:9000/", Bucket: "greenlight"}, "posters/1/a b.jpg", "http://localhost:9000/greenlight/posters/1/a%20b.jpg"},
+		{&S3Store{Endpoint: "http://localhost:9000", Bucket: "greenlight", PublicURL: "https://cdn.example.com/"}, "posters/1.jpg", "https://cdn.example.com/posters/1.jpg"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.store.URL(tt.key); got != tt.want {
+			t.Errorf("URL(%q) = %q; want %q", tt.key, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The LocalStore keeps blobs as files under a directory on the local filesystem. It's meant for development and single-server
+// deployments; the API serves the files itself, under BaseURL.
+type LocalStore struct {
+	Dir     string // The directory blobs are stored in
+	BaseURL string // The URL prefix the blobs are served from, like "/v1/blobs"
+}
+
+// The Put() method writes the blob to a temporary file and then renames it into place, so that readers never see a half-written file.
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	name := filepath.Join(s.Dir, filepath.FromSlash(key))
+
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+// The Get() method opens a blob's file. The content type is worked out from the file extension, since every key we create has one.
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	if !validKey(key) {
+		return nil, "", ErrNotFound
+	}
+
+	f, err := os.Open(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, "", ErrNotFound
+		default:
+			return nil, "", err
+		}
+	}
+
+	// Don't serve directories.
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, "", ErrNotFound
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return f, contentType, nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *LocalStore) URL(key string) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + key
+}
@@ -0,0 +1,211 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The S3Store keeps blobs in a bucket on Amazon S3, or any service with an S3-compatible API (like MinIO, which makes a handy
+// local stand-in for development and testing). Requests are signed with AWS Signature Version 4, and use path-style URLs
+// (endpoint/bucket/key), which every S3-compatible service supports.
+type S3Store struct {
+	Endpoint  string       // The service URL, like "https://s3.eu-west-1.amazonaws.com" or "http://localhost:9000"
+	Region    string       // The region used for signing, like "eu-west-1" ("us-east-1" for most stand-ins)
+	Bucket    string       // The bucket name
+	AccessKey string       // The access key ID
+	SecretKey string       // The secret access key
+	PublicURL string       // The URL prefix clients download blobs from, like a CDN. Defaults to Endpoint/Bucket
+	Client    *http.Client // The HTTP client to use. Defaults to one with a 30-second timeout
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	// Blob keys are never reused, so the object can be cached by clients and CDNs forever.
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Cache-Control", "public, max-age=31536000, immutable")
+
+	res, err := s.do(ctx, http.MethodPut, key, data, headers)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return s.responseError(http.MethodPut, key, res)
+	}
+
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	if !validKey(key) {
+		return nil, "", ErrNotFound
+	}
+
+	res, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		return res.Body, res.Header.Get("Content-Type"), nil
+	case http.StatusNotFound:
+		res.Body.Close()
+		return nil, "", ErrNotFound
+	default:
+		defer res.Body.Close()
+		return nil, "", s.responseError(http.MethodGet, key, res)
+	}
+}
+
+// The Delete() method deletes an object. S3 returns 204 No Content whether or not the object existed, but some
+// stand-ins return 404 Not Found for a missing object, so we accept that too.
+func (s *S3Store) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	res, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	default:
+		return s.responseError(http.MethodDelete, key, res)
+	}
+}
+
+func (s *S3Store) URL(key string) string {
+	base := s.PublicURL
+	if base == "" {
+		base = strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket
+	}
+
+	return strings.TrimSuffix(base, "/") + "/" + escapePath(key)
+}
+
+// The do() method sends a signed request for an object in the bucket.
+func (s *S3Store) do(ctx context.Context, method, key string, body []byte, headers http.Header) (*http.Response, error) {
+	url := strings.TrimSuffix(s.Endpoint, "/") + "/" + s.Bucket + "/" + escapePath(key)
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	s.sign(req, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	return client.Do(req)
+}
+
+// The sign() method adds an AWS Signature Version 4 Authorization header to a request. See
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv.html for how the signature is built.
+func (s *S3Store) sign(req *http.Request, body []byte, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := sha256.Sum256(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+	// Sign the host header and every header we've set, in sorted order by their lowercase names.
+	signed := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		signed[strings.ToLower(name)] = strings.TrimSpace(req.Header.Get(name))
+	}
+
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // No query string
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.AccessKey, scope, signedHeaders, signature))
+}
+
+// The responseError() method turns an unexpected response into an error, including the start of the response body, which
+// holds S3's XML error document.
+func (s *S3Store) responseError(method, key string, res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	return fmt.Errorf("s3: %s %s: %s: %s", method, key, res.Status, strings.TrimSpace(string(body)))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// The escapePath() function percent-encodes each segment of a key, the way S3 expects: everything except letters, digits
+// and "-._~" is encoded, and the slashes between segments are left alone.
+func escapePath(key string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,258 @@
+package data
+
+import (
+	"container/list"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieCacheChannel is the PostgreSQL notification channel that the movie cache listens on. Triggers on the movies table and
+// the tables holding a movie's details (see migration 000020) send the ID of each movie that changes, or "*" when a change
+// could affect any number of movies, like renaming a genre.
+const MovieCacheChannel = "movie_cache"
+
+// The MovieCache type is an in-memory cache of the movies returned by MovieModel.Get(). It holds at most size movies, evicting
+// the least recently used one to make room for another, and each movie for no longer than ttl, which bounds how stale a movie
+// can get if a change to it is somehow missed.
+//
+// Changes made through the MovieModel invalidate the cached movie straight away. Every other change, including those made by
+// other instances of the API, reaches the cache as a notification on MovieCacheChannel (see HandleNotification()).
+type MovieCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[int64]*list.Element
+	lru     *list.List // Most recently used at the front
+	loads   map[int64]*movieLoad
+	stats   MovieCacheStats
+}
+
+type movieCacheEntry struct {
+	movie   *Movie
+	expires time.Time
+}
+
+// errMovieLoadPanicked is the error that callers waiting for a load get when the lookup panicked.
+var errMovieLoadPanicked = errors.New("movie lookup panicked")
+
+// The movieLoad struct is a lookup of a movie that isn't in the cache. Concurrent misses for the same movie wait for the one
+// lookup that's already in progress, rather than all querying the database (the same idea as golang.org/x/sync/singleflight).
+type movieLoad struct {
+	done  chan struct{}
+	movie *Movie
+	err   error
+}
+
+// The MovieCacheStats struct holds the cache's counters, which are published as metrics.
+type MovieCacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+	Size          int   `json:"size"`
+}
+
+// The NewMovieCache() function returns an empty MovieCache holding up to size movies, each for up to ttl.
+func NewMovieCache(size int, ttl time.Duration) *MovieCache {
+	return &MovieCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[int64]*list.Element),
+		lru:     list.New(),
+		loads:   make(map[int64]*movieLoad),
+	}
+}
+
+// The get() method returns a copy of the cached movie with the given ID, or calls load to look it up and caches the result.
+// Only movies are cached, not errors, so a movie that doesn't exist yet is looked up again every time.
+func (c *MovieCache) get(id int64, load func(int64) (*Movie, error)) (*Movie, error) {
+	c.mu.Lock()
+
+	if element, ok := c.entries[id]; ok {
+		entry := element.Value.(*movieCacheEntry)
+
+		if time.Now().Before(entry.expires) {
+			c.lru.MoveToFront(element)
+			c.stats.Hits++
+			c.mu.Unlock()
+
+			return entry.movie.clone(), nil
+		}
+
+		c.remove(id)
+	}
+
+	c.stats.Misses++
+
+	if l, ok := c.loads[id]; ok {
+		c.mu.Unlock()
+		<-l.done
+
+		if l.err != nil {
+			return nil, l.err
+		}
+
+		return l.movie.clone(), nil
+	}
+
+	l := &movieLoad{done: make(chan struct{}), err: errMovieLoadPanicked}
+	c.loads[id] = l
+	c.mu.Unlock()
+
+	// The waiters are released however the load ends. If load() panics, the error above is what they get, and the load is
+	// forgotten so that the next miss tries again, while the panic carries on up to our caller.
+	loaded := false
+
+	defer func() {
+		if !loaded {
+			c.mu.Lock()
+			if c.loads[id] == l {
+				delete(c.loads, id)
+			}
+			c.mu.Unlock()
+		}
+
+		close(l.done)
+	}()
+
+	l.movie, l.err = load(id)
+	loaded = true
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// If the movie was invalidated while we were looking it up, the load will have been forgotten, and what we found may
+	// already be out of date, so we don't cache it. Any callers waiting for the load are sharing the movie, so we still
+	// return a copy.
+	if c.loads[id] != l {
+		if l.err != nil {
+			return nil, l.err
+		}
+
+		return l.movie.clone(), nil
+	}
+
+	delete(c.loads, id)
+
+	if l.err != nil {
+		return nil, l.err
+	}
+
+	c.entries[id] = c.lru.PushFront(&movieCacheEntry{movie: l.movie, expires: time.Now().Add(c.ttl)})
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back().Value.(*movieCacheEntry).movie.ID)
+		c.stats.Evictions++
+	}
+
+	return l.movie.clone(), nil
+}
+
+// The remove() method removes a movie from the cache. The caller must hold the lock.
+func (c *MovieCache) remove(id int64) {
+	if element, ok := c.entries[id]; ok {
+		c.lru.Remove(element)
+		delete(c.entries, id)
+	}
+}
+
+// The Invalidate() method removes a movie from the cache, and stops any lookup of it that's in progress from being cached. It's
+// safe to call on a nil MovieCache, which caches nothing.
+func (c *MovieCache) Invalidate(id int64) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(id)
+	delete(c.loads, id)
+	c.stats.Invalidations++
+}
+
+// The Flush() method empties the cache.
+func (c *MovieCache) Flush() {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[int64]*list.Element)
+	c.lru.Init()
+	c.loads = make(map[int64]*movieLoad)
+	c.stats.Invalidations++
+}
+
+// The HandleNotification() method invalidates the movie named by a notification on MovieCacheChannel. For "*", or a payload we
+// don't understand, we empty the whole cache.
+func (c *MovieCache) HandleNotification(n *pq.Notification) {
+	id, err := strconv.ParseInt(n.Extra, 10, 64)
+	if err != nil {
+		c.Flush()
+		return
+	}
+
+	c.Invalidate(id)
+}
+
+// The Stats() method returns the cache's counters.
+func (c *MovieCache) Stats() MovieCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Size = c.lru.Len()
+
+	return stats
+}
+
+// The clone() method returns a deep copy of a movie. Handlers change the movies they're given (Localize() replaces the title,
+// for example), so the cache hands out copies and keeps the original to itself.
+func (movie *Movie) clone() *Movie {
+	if movie == nil {
+		return nil
+	}
+
+	c := *movie
+
+	if movie.Genres != nil {
+		c.Genres = append([]string{}, movie.Genres...)
+	}
+
+	if movie.Poster != nil {
+		poster := *movie.Poster
+		poster.URLs = cloneStringMap(movie.Poster.URLs)
+		c.Poster = &poster
+	}
+
+	c.ExternalIDs = cloneStringMap(movie.ExternalIDs)
+	c.Titles = cloneStringMap(movie.Titles)
+	c.Releases = cloneStringMap(movie.Releases)
+
+	if movie.DeletedAt != nil {
+		deletedAt := *movie.DeletedAt
+		c.DeletedAt = &deletedAt
+	}
+
+	return &c
+}
+
+func cloneStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+
+	c := make(map[string]string, len(m))
+	for key, value := range m {
+		c[key] = value
+	}
+
+	return c
+}
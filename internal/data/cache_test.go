@@ -0,0 +1,56 @@
+package data
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestMovieCacheLoadPanic checks that a lookup which panics releases the callers waiting for it, and is forgotten so that the
+// next miss looks the movie up again.
+func TestMovieCacheLoadPanic(t *testing.T) {
+	c := NewMovieCache(10, time.Minute)
+
+	started := make(chan struct{})
+	waiting := make(chan struct{})
+	errs := make(chan error)
+
+	go func() {
+		defer func() { recover() }()
+
+		c.get(1, func(int64) (*Movie, error) {
+			close(started)
+			<-waiting
+			panic("lookup failed")
+		})
+	}()
+
+	<-started
+
+	go func() {
+		_, err := c.get(1, func(int64) (*Movie, error) {
+			return nil, errors.New("waiter looked the movie up itself")
+		})
+		errs <- err
+	}()
+
+	// Give the second caller time to start waiting for the first one's lookup.
+	time.Sleep(50 * time.Millisecond)
+	close(waiting)
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errMovieLoadPanicked) {
+			t.Errorf("waiter got error %v; want %v", err, errMovieLoadPanicked)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter was still blocked after the lookup panicked")
+	}
+
+	movie, err := c.get(1, func(id int64) (*Movie, error) {
+		return &Movie{ID: id}, nil
+	})
+	if err != nil || movie.ID != 1 {
+		t.Errorf("got (%v, %v) after the panic; want movie 1", movie, err)
+	}
+}
@@ -0,0 +1,564 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrAlreadyInCollection error, for when a movie is added to a collection it's already in.
+var (
+	ErrAlreadyInCollection = errors.New("already in collection")
+)
+
+// A Collection is a named, ordered list of movies curated by a user, like "Best of 1970s noir". Public collections are listed
+// for everyone; private ones can only be seen by their owner, or by anyone who has the share link.
+type Collection struct {
+	ID          int64                `json:"id"`
+	CreatedAt   time.Time            `json:"created_at"`
+	OwnerID     int64                `json:"owner_id"`
+	Name        string               `json:"name"`
+	Description string               `json:"description"`
+	Public      bool                 `json:"public"`
+	ShareToken  string               `json:"-"`
+	ShareURL    string               `json:"share_url,omitempty"`
+	MovieCount  int32                `json:"movie_count"`
+	Version     int32                `json:"version"`
+	Movies      []*CollectionItem    `json:"movies,omitempty"`
+	Removed     []*CollectionRemoval `json:"removed_movies,omitempty"`
+}
+
+// A CollectionItem is a movie in a collection, at a position starting from 1.
+type CollectionItem struct {
+	Position int32     `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+// A CollectionRemoval records a movie that was taken out of a collection because the movie itself was deleted, so the
+// collection's owner can see what happened to it. If the movie is restored from the trash, it goes back into the collection
+// and the record is removed.
+type CollectionRemoval struct {
+	MovieID    int64     `json:"movie_id"`
+	MovieTitle string    `json:"movie_title"`
+	Position   int32     `json:"position"`
+	RemovedAt  time.Time `json:"removed_at"`
+}
+
+// Define a CollectionModel struct type which wraps a sql.DB connection pool.
+type CollectionModel struct {
+	DB DBTX
+}
+
+// The collectionMovies table holds the movies in each collection, in order.
+var collectionMovies = orderedList{table: "collection_movies", scope: "collection_id", item: "movie_id"}
+
+func ValidateCollection(v *validator.Validator, collection *Collection) {
+	v.Check(collection.Name != "", "name", "must be provided")
+	v.Check(len(collection.Name) <= 200, "name", "must not be more than 200 bytes long")
+
+	v.Check(len(collection.Description) <= 10_000, "description", "must not be more than 10000 bytes long")
+}
+
+// The generateShareToken() function returns a random, unguessable token for a collection's share link, in the same format as our authentication tokens.
+func generateShareToken() (string, error) {
+	randomBytes := make([]byte, 16)
+
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// The collectionSQL constant holds the columns selected for a collection, in the order scanCollection() expects them.
+const collectionSQL = `id, created_at, owner_id, name, description, public, share_token,
+	(SELECT count(*) FROM collection_movies cm INNER JOIN movies ON movies.id = cm.movie_id
+	 WHERE cm.collection_id = collections.id AND movies.deleted_at IS NULL), version`
+
+// The scanCollection() function returns the Collection and scan destinations for the columns in collectionSQL.
+func scanCollection() (*Collection, []interface{}) {
+	var collection Collection
+
+	return &collection, []interface{}{
+		&collection.ID,
+		&collection.CreatedAt,
+		&collection.OwnerID,
+		&collection.Name,
+		&collection.Description,
+		&collection.Public,
+		&collection.ShareToken,
+		&collection.MovieCount,
+		&collection.Version,
+	}
+}
+
+func (m CollectionModel) Insert(collection *Collection) error {
+	token, err := generateShareToken()
+	if err != nil {
+		return err
+	}
+
+	collection.ShareToken = token
+
+	query := `
+		INSERT INTO collections (owner_id, name, description, public, share_token)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at, version`
+
+	args := []interface{}{collection.OwnerID, collection.Name, collection.Description, collection.Public, collection.ShareToken}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.ID, &collection.CreatedAt, &collection.Version)
+}
+
+// The Get() method fetches a collection by its ID, without its movies.
+func (m CollectionModel) Get(id int64) (*Collection, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	return m.get(`SELECT `+collectionSQL+` FROM collections WHERE id = $1`, id)
+}
+
+// The GetByShareToken() method fetches a collection by the token in its share link, without its movies.
+func (m CollectionModel) GetByShareToken(token string) (*Collection, error) {
+	return m.get(`SELECT `+collectionSQL+` FROM collections WHERE share_token = $1`, token)
+}
+
+func (m CollectionModel) get(query string, arg interface{}) (*Collection, error) {
+	collection, dest := scanCollection()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, arg).Scan(dest...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return collection, nil
+}
+
+// The GetAll() method returns a page of the collections a user can see: the public ones, plus their own. If mine is true,
+// only the user's own collections are returned. Anonymous users (with an ID of 0) only see public collections.
+func (m CollectionModel) GetAll(userID int64, mine bool, filters Filters) ([]*Collection, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), %s
+		FROM collections
+		WHERE owner_id = $1 OR (public AND NOT $2)
+		ORDER BY %s %s, id ASC
+		LIMIT $3 OFFSET $4`, collectionSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, mine, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	collections := []*Collection{}
+
+	for rows.Next() {
+		collection, dest := scanCollection()
+
+		err := rows.Scan(append([]interface{}{&totalRecords}, dest...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		collections = append(collections, collection)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return collections, metadata, nil
+}
+
+// The Update() method uses the version number for optimistic locking, in the same way as MovieModel.Update().
+// Changes to the movies in a collection don't change its version.
+func (m CollectionModel) Update(collection *Collection) error {
+	query := `
+		UPDATE collections
+		SET name = $1, description = $2, public = $3, version = version + 1
+		WHERE id = $4 AND version = $5
+		RETURNING version`
+
+	args := []interface{}{collection.Name, collection.Description, collection.Public, collection.ID, collection.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&collection.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m CollectionModel) Delete(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM collections WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The Items() method returns the movies in a collection, in order.
+func (m CollectionModel) Items(collectionID int64) ([]*CollectionItem, error) {
+	query := `
+		SELECT c.position, c.added_at, ` + listMovieSQL + `
+		FROM collection_movies c
+		INNER JOIN movies ON movies.id = c.movie_id
+		WHERE c.collection_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY c.position ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []*CollectionItem{}
+
+	for rows.Next() {
+		var item CollectionItem
+
+		movie, dest := scanListMovie()
+
+		err := rows.Scan(append([]interface{}{&item.Position, &item.AddedAt}, dest...)...)
+		if err != nil {
+			return nil, err
+		}
+
+		item.Movie = movie
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// The Removals() method returns the movies that have been taken out of a collection because they were deleted, most recent first.
+func (m CollectionModel) Removals(collectionID int64) ([]*CollectionRemoval, error) {
+	query := `
+		SELECT movie_id, movie_title, position, removed_at
+		FROM collection_removals
+		WHERE collection_id = $1
+		ORDER BY removed_at DESC, id DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, collectionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	removals := []*CollectionRemoval{}
+
+	for rows.Next() {
+		var removal CollectionRemoval
+
+		err := rows.Scan(&removal.MovieID, &removal.MovieTitle, &removal.Position, &removal.RemovedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		removals = append(removals, &removal)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return removals, nil
+}
+
+// The AddMovie() method adds a movie to a collection at the given position, moving the movies at and after that position down one
+// place. A position of 0 adds it to the end. It returns the movie's position.
+// If the movie or collection doesn't exist we return ErrRecordNotFound, and if the movie is already in the collection we return ErrAlreadyInCollection.
+func (m CollectionModel) AddMovie(collectionID, movieID int64, position int32) (int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the movie against deletion before locking the collection. MovieModel.Delete() and Purge() lock the movie first too,
+	// so a movie can't be deleted while it's being added to a collection, and the two can't deadlock.
+	err = checkMovieNotDeleted(ctx, tx, movieID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return 0, err
+	}
+
+	position, err = collectionMovies.makeRoom(ctx, tx, collectionID, position)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO collection_movies (collection_id, movie_id, position) VALUES ($1, $2, $3)`, collectionID, movieID, position)
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505": // unique_violation
+			return 0, ErrAlreadyInCollection
+		default:
+			return 0, err
+		}
+	}
+
+	return position, tx.Commit()
+}
+
+// The MoveMovie() method moves a movie within a collection to a new position, and returns its final position.
+func (m CollectionModel) MoveMovie(collectionID, movieID int64, position int32) (int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return 0, err
+	}
+
+	position, err = collectionMovies.move(ctx, tx, collectionID, movieID, position)
+	if err != nil {
+		return 0, err
+	}
+
+	return position, tx.Commit()
+}
+
+// The RemoveMovie() method takes a movie out of a collection, and closes the gap it leaves behind.
+func (m CollectionModel) RemoveMovie(collectionID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockCollection(ctx, tx, collectionID)
+	if err != nil {
+		return err
+	}
+
+	err = collectionMovies.remove(ctx, tx, collectionID, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The lockCollection() function locks a collection's list of movies for the rest of the transaction, by locking the collection's row.
+// It returns ErrRecordNotFound if the collection doesn't exist.
+func lockCollection(ctx context.Context, tx DBTX, collectionID int64) error {
+	var id int64
+
+	err := tx.QueryRowContext(ctx, `SELECT id FROM collections WHERE id = $1 FOR NO KEY UPDATE`, collectionID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The removeFromCollections() function is called by MovieModel.Delete() to take a movie that's going into the trash out of every
+// collection it's in, recording each removal in collection_removals. The caller must already hold a lock on the movie's row.
+// Collections are locked in ID order, so that two of these running at once can't deadlock.
+func removeFromCollections(ctx context.Context, tx DBTX, movieID int64, title string) error {
+	rows, err := tx.QueryContext(ctx, `SELECT collection_id FROM collection_movies WHERE movie_id = $1 ORDER BY collection_id`, movieID)
+	if err != nil {
+		return err
+	}
+
+	var collectionIDs []int64
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		collectionIDs = append(collectionIDs, id)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// If a collection is deleted, or the movie is taken out of it, before we get its lock, there's nothing left to remove.
+	for _, collectionID := range collectionIDs {
+		err = lockCollection(ctx, tx, collectionID)
+		if errors.Is(err, ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		query := `
+			INSERT INTO collection_removals (collection_id, movie_id, movie_title, position)
+			SELECT collection_id, movie_id, $3, position
+			FROM collection_movies
+			WHERE collection_id = $1 AND movie_id = $2`
+
+		_, err = tx.ExecContext(ctx, query, collectionID, movieID, title)
+		if err != nil {
+			return err
+		}
+
+		err = collectionMovies.remove(ctx, tx, collectionID, movieID)
+		if err != nil && !errors.Is(err, ErrRecordNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The restoreToCollections() function is called by MovieModel.Restore() to put a movie back into the collections it was taken
+// out of when it was deleted, at the positions it had then (or the end, if a collection has got shorter since), and to remove
+// the records of its removal. The caller must already hold a lock on the movie's row. Collections are locked in ID order, like
+// removeFromCollections() does.
+func restoreToCollections(ctx context.Context, tx DBTX, movieID int64) error {
+	// A movie can have been deleted and restored before, but each restore removes the records it used, so there's at most one
+	// for each collection. DISTINCT ON guards against any left over from before movies could be restored.
+	query := `
+		SELECT DISTINCT ON (collection_id) collection_id, position
+		FROM collection_removals
+		WHERE movie_id = $1
+		ORDER BY collection_id, removed_at DESC, id DESC`
+
+	rows, err := tx.QueryContext(ctx, query, movieID)
+	if err != nil {
+		return err
+	}
+
+	type removal struct {
+		collectionID int64
+		position     int32
+	}
+
+	var removals []removal
+
+	for rows.Next() {
+		var r removal
+
+		err := rows.Scan(&r.collectionID, &r.position)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		removals = append(removals, r)
+	}
+
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	// If a collection has been deleted since, its removal records went with it.
+	for _, r := range removals {
+		err = lockCollection(ctx, tx, r.collectionID)
+		if errors.Is(err, ErrRecordNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		position, err := collectionMovies.makeRoom(ctx, tx, r.collectionID, r.position)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `INSERT INTO collection_movies (collection_id, movie_id, position) VALUES ($1, $2, $3)`, r.collectionID, movieID, position)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM collection_removals WHERE collection_id = $1 AND movie_id = $2`, r.collectionID, movieID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
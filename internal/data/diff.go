@@ -0,0 +1,255 @@
+package data
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// The FieldChange struct holds the old and new values of a field that was changed.
+type FieldChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+// The ListChange struct describes a change to a list, like a movie's genres. Reordered is set when the list has the same
+// items as before, but in a different order.
+type ListChange struct {
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Reordered bool     `json:"reordered,omitempty"`
+}
+
+// The MapChange struct describes a change to a map, like a movie's external IDs, by key.
+type MapChange struct {
+	Added   map[string]string      `json:"added,omitempty"`
+	Removed map[string]string      `json:"removed,omitempty"`
+	Changed map[string]FieldChange `json:"changed,omitempty"`
+}
+
+// The MovieDiff struct describes the differences between two versions of a movie. Only the fields that changed are set.
+type MovieDiff struct {
+	From        int32        `json:"from"`
+	To          int32        `json:"to"`
+	Title       *FieldChange `json:"title,omitempty"`
+	Year        *FieldChange `json:"year,omitempty"`
+	Runtime     *FieldChange `json:"runtime,omitempty"`
+	Genres      *ListChange  `json:"genres,omitempty"`
+	ExternalIDs *MapChange   `json:"external_ids,omitempty"`
+	Titles      *MapChange   `json:"titles,omitempty"`
+	Status      *FieldChange `json:"status,omitempty"`
+	ReleaseDate *FieldChange `json:"release_date,omitempty"`
+	Releases    *MapChange   `json:"releases,omitempty"`
+}
+
+// The DiffMovies() function compares two versions of a movie, like the ones in two revisions, field by field.
+func DiffMovies(from, to *Movie) *MovieDiff {
+	diff := &MovieDiff{
+		From:        from.Version,
+		To:          to.Version,
+		Title:       diffField(from.Title, to.Title),
+		Year:        diffField(from.Year, to.Year),
+		Runtime:     diffField(from.Runtime, to.Runtime),
+		Genres:      diffList(from.Genres, to.Genres),
+		ExternalIDs: diffMap(from.ExternalIDs, to.ExternalIDs),
+		Titles:      diffMap(from.Titles, to.Titles),
+		Status:      diffField(from.Status, to.Status),
+		ReleaseDate: diffField(from.ReleaseDate, to.ReleaseDate),
+		Releases:    diffMap(from.Releases, to.Releases),
+	}
+
+	return diff
+}
+
+func diffField[T comparable](old, new T) *FieldChange {
+	if old == new {
+		return nil
+	}
+
+	return &FieldChange{Old: old, New: new}
+}
+
+func diffList(old, new []string) *ListChange {
+	if slices.Equal(old, new) {
+		return nil
+	}
+
+	change := &ListChange{}
+
+	for _, item := range new {
+		if !slices.Contains(old, item) {
+			change.Added = append(change.Added, item)
+		}
+	}
+
+	for _, item := range old {
+		if !slices.Contains(new, item) {
+			change.Removed = append(change.Removed, item)
+		}
+	}
+
+	change.Reordered = len(change.Added) == 0 && len(change.Removed) == 0
+
+	return change
+}
+
+func diffMap(old, new map[string]string) *MapChange {
+	change := &MapChange{}
+	changed := false
+
+	for key, value := range new {
+		oldValue, ok := old[key]
+
+		switch {
+		case !ok:
+			if change.Added == nil {
+				change.Added = map[string]string{}
+			}
+			change.Added[key] = value
+			changed = true
+		case oldValue != value:
+			if change.Changed == nil {
+				change.Changed = map[string]FieldChange{}
+			}
+			change.Changed[key] = FieldChange{Old: oldValue, New: value}
+			changed = true
+		}
+	}
+
+	for key, value := range old {
+		if _, ok := new[key]; !ok {
+			if change.Removed == nil {
+				change.Removed = map[string]string{}
+			}
+			change.Removed[key] = value
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+
+	return change
+}
+
+// The PatchOperation struct is a single operation in an RFC 6902 JSON Patch document, like
+// {"op": "replace", "path": "/title", "value": "Casablanca"}.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// The MarshalJSON() method leaves the value out of remove operations, which don't have one. Every other operation must have
+// a value, even when it's null, false, 0 or empty, so omitempty wouldn't do.
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+
+	// The operation type has the same fields but none of the methods, so marshalling it doesn't call this method again.
+	type operation PatchOperation
+
+	return json.Marshal(operation(op))
+}
+
+// The MoviePatch() function returns the JSON Patch which turns the JSON representation of one version of a movie into the
+// other. Fields which are left out of the JSON when they're empty, like year or genres, are added or removed as a whole when
+// they become empty or stop being empty; otherwise they're replaced, or for maps, patched key by key.
+func MoviePatch(from, to *Movie) []PatchOperation {
+	ops := []PatchOperation{}
+
+	ops = appendFieldPatch(ops, "/title", from.Title, to.Title, false)
+	ops = appendFieldPatch(ops, "/year", from.Year, to.Year, true)
+	ops = appendFieldPatch(ops, "/runtime", from.Runtime, to.Runtime, true)
+
+	if !slices.Equal(from.Genres, to.Genres) {
+		ops = appendWholePatch(ops, "/genres", len(from.Genres) == 0, len(to.Genres) == 0, to.Genres)
+	}
+
+	ops = appendMapPatch(ops, "/external_ids", from.ExternalIDs, to.ExternalIDs)
+	ops = appendMapPatch(ops, "/titles", from.Titles, to.Titles)
+	ops = appendFieldPatch(ops, "/status", from.Status, to.Status, false)
+	ops = appendFieldPatch(ops, "/release_date", from.ReleaseDate, to.ReleaseDate, true)
+	ops = appendMapPatch(ops, "/releases", from.Releases, to.Releases)
+
+	return ops
+}
+
+// The appendFieldPatch() function adds the operation for a changed field, if it has changed. If omitEmpty is set, the field
+// is left out of the JSON when it has its zero value.
+func appendFieldPatch[T comparable](ops []PatchOperation, path string, old, new T, omitEmpty bool) []PatchOperation {
+	if old == new {
+		return ops
+	}
+
+	var zero T
+
+	if !omitEmpty {
+		return append(ops, PatchOperation{Op: "replace", Path: path, Value: new})
+	}
+
+	return appendWholePatch(ops, path, old == zero, new == zero, new)
+}
+
+// The appendWholePatch() function adds the operation that replaces a field that has changed as a whole, adding or removing it
+// if it was or has become empty.
+func appendWholePatch(ops []PatchOperation, path string, oldEmpty, newEmpty bool, value interface{}) []PatchOperation {
+	switch {
+	case oldEmpty:
+		return append(ops, PatchOperation{Op: "add", Path: path, Value: value})
+	case newEmpty:
+		return append(ops, PatchOperation{Op: "remove", Path: path})
+	default:
+		return append(ops, PatchOperation{Op: "replace", Path: path, Value: value})
+	}
+}
+
+// The appendMapPatch() function adds the operations for a map that has changed, in key order so the patch is predictable.
+func appendMapPatch(ops []PatchOperation, path string, old, new map[string]string) []PatchOperation {
+	if len(old) == 0 || len(new) == 0 {
+		if len(old) == len(new) {
+			return ops
+		}
+
+		return appendWholePatch(ops, path, len(old) == 0, len(new) == 0, new)
+	}
+
+	keys := []string{}
+	for key := range old {
+		keys = append(keys, key)
+	}
+	for key := range new {
+		if _, ok := old[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+		keyPath := path + "/" + escapePointer(key)
+
+		switch {
+		case !inOld:
+			ops = append(ops, PatchOperation{Op: "add", Path: keyPath, Value: newValue})
+		case !inNew:
+			ops = append(ops, PatchOperation{Op: "remove", Path: keyPath})
+		case oldValue != newValue:
+			ops = append(ops, PatchOperation{Op: "replace", Path: keyPath, Value: newValue})
+		}
+	}
+
+	return ops
+}
+
+// The escapePointer() function escapes a key for use in a JSON Pointer (RFC 6901), where "~" and "/" are special.
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
@@ -0,0 +1,30 @@
+package data
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPatchOperationJSON(t *testing.T) {
+	tests := []struct {
+		op   PatchOperation
+		want string
+	}{
+		{PatchOperation{Op: "replace", Path: "/title", Value: "Casablanca"}, `{"op":"replace","path":"/title","value":"Casablanca"}`},
+		{PatchOperation{Op: "replace", Path: "/title", Value: ""}, `{"op":"replace","path":"/title","value":""}`},
+		{PatchOperation{Op: "replace", Path: "/year", Value: 0}, `{"op":"replace","path":"/year","value":0}`},
+		{PatchOperation{Op: "add", Path: "/runtime", Value: nil}, `{"op":"add","path":"/runtime","value":null}`},
+		{PatchOperation{Op: "remove", Path: "/genres"}, `{"op":"remove","path":"/genres"}`},
+	}
+
+	for _, tt := range tests {
+		js, err := json.Marshal(tt.op)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(js) != tt.want {
+			t.Errorf("got %s; want %s", js, tt.want)
+		}
+	}
+}
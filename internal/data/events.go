@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MovieEventsChannel is the PostgreSQL notification channel that every movie event is sent on as it's committed (see migration
+// 000021 and 000022). The payload is the event as JSON.
+const MovieEventsChannel = "movie_events"
+
+// The operations a movie event can describe. Moving a movie into the trash is a delete, and restoring it is an insert.
+const (
+	MovieEventInsert = "insert"
+	MovieEventUpdate = "update"
+	MovieEventDelete = "delete"
+)
+
+// The MovieEvent struct is an entry in the change feed: a movie was added, changed or deleted. Seq numbers the events in the
+// order they were recorded, so it can be used to pick up the feed where a listener left off. Genres are the movie's genres
+// when the change was committed, which may not be the ones it has now.
+type MovieEvent struct {
+	Seq       int64     `json:"seq"`
+	ID        int64     `json:"id"` // The movie's ID
+	Version   int32     `json:"version"`
+	Operation string    `json:"operation"`
+	Genres    []string  `json:"genres"`
+	Time      time.Time `json:"time"`
+}
+
+// The HasGenres() method reports whether the movie had all of the given genres when the event was recorded. The genres should
+// be canonical names (see GenreModel.Resolve()).
+func (e MovieEvent) HasGenres(genres []string) bool {
+	for _, genre := range genres {
+		if !slices.Contains(e.Genres, genre) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The ParseMovieEvent() function decodes the payload of a notification on MovieEventsChannel.
+func ParseMovieEvent(payload string) (MovieEvent, error) {
+	var event MovieEvent
+
+	err := json.Unmarshal([]byte(payload), &event)
+
+	return event, err
+}
+
+// Define a MovieEventModel struct type which wraps a sql.DB connection pool.
+type MovieEventModel struct {
+	DB DBTX
+}
+
+// The Latest() method returns the seq of the most recent event, or 0 if there haven't been any.
+func (m MovieEventModel) Latest() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var seq int64
+
+	err := m.DB.QueryRowContext(ctx, `SELECT COALESCE(max(seq), 0) FROM movie_events`).Scan(&seq)
+
+	return seq, err
+}
+
+// The Since() method returns up to limit of the events after the given seq, oldest first.
+func (m MovieEventModel) Since(seq int64, limit int) ([]MovieEvent, error) {
+	query := `
+		SELECT seq, movie_id, version, operation, genres, created_at
+		FROM movie_events
+		WHERE seq > $1
+		ORDER BY seq
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, seq, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []MovieEvent{}
+
+	for rows.Next() {
+		var event MovieEvent
+
+		err := rows.Scan(&event.Seq, &event.ID, &event.Version, &event.Operation, pq.Array(&event.Genres), &event.Time)
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, event)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// The Prune() method deletes the events that are older than the retention period, since nobody will need to catch up that far,
+// and returns how many it deleted.
+func (m MovieEventModel) Prune(retention time.Duration) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_events WHERE created_at < NOW() - $1 * interval '1 second'`, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,144 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The sources of external IDs we record for movies, in the order we list them in.
+var ExternalIDSources = []string{"imdb", "tmdb", "wikidata"}
+
+// The format of the IDs from each source: IMDb title IDs like "tt0111161", TMDB's numeric movie IDs, and Wikidata item IDs like "Q172241".
+var externalIDFormats = map[string]*regexp.Regexp{
+	"imdb":     regexp.MustCompile(`^tt\d{7,8}$`),
+	"tmdb":     regexp.MustCompile(`^[1-9]\d{0,9}$`),
+	"wikidata": regexp.MustCompile(`^Q[1-9]\d{0,9}$`),
+}
+
+// An ExternalIDConflictError is returned when a movie is given an external ID that already belongs to another movie.
+// MovieID is the ID of that other movie, and Deleted is set when it's in the trash. A movie keeps its external IDs in the trash,
+// so that it has them back if it's restored, which means the client may want to restore it rather than create it again.
+// In the rare case of two requests claiming the same ID at the same moment, the database's
+// unique constraint catches the loser, and we don't know which ID it was or who has it, so all the fields are empty.
+type ExternalIDConflictError struct {
+	Source     string
+	ExternalID string
+	MovieID    int64
+	Deleted    bool
+}
+
+func (e *ExternalIDConflictError) Error() string {
+	if e.Source == "" {
+		return "an external ID already belongs to another movie"
+	}
+
+	if e.Deleted {
+		return fmt.Sprintf("%s ID %q already belongs to a movie in the trash", e.Source, e.ExternalID)
+	}
+
+	return fmt.Sprintf("%s ID %q already belongs to another movie", e.Source, e.ExternalID)
+}
+
+// The ValidExternalID() function reports whether an ID is in the right format for its source.
+func ValidExternalID(source, id string) bool {
+	rx, ok := externalIDFormats[source]
+	return ok && validator.Matches(id, rx)
+}
+
+func ValidateExternalIDs(v *validator.Validator, ids map[string]string) {
+	for source, id := range ids {
+		if _, ok := externalIDFormats[source]; !ok {
+			v.AddError("external_ids", fmt.Sprintf("contains unknown source %q", source))
+			continue
+		}
+
+		v.Check(ValidExternalID(source, id), "external_ids", fmt.Sprintf("contains an invalid %s ID", source))
+	}
+}
+
+// A movie's external IDs are selected with this subquery, which returns them as a JSON object keyed by source, or NULL if there aren't any.
+const movieExternalIDsSQL = `(SELECT jsonb_object_agg(source, external_id) FROM movie_external_ids WHERE movie_id = movies.id)`
+
+// The setMovieExternalIDs() function replaces the external IDs for a movie. If any of them already belongs to another movie,
+// it returns an *ExternalIDConflictError.
+func setMovieExternalIDs(ctx context.Context, tx DBTX, movieID int64, ids map[string]string) error {
+	sources := []string{}
+	externalIDs := []string{}
+
+	for source, id := range ids {
+		sources = append(sources, source)
+		externalIDs = append(externalIDs, id)
+	}
+
+	// Look for a movie that already has one of the IDs first, so we can tell the client which movie it is.
+	query := `
+		SELECT e.source, e.external_id, e.movie_id, m.deleted_at IS NOT NULL
+		FROM movie_external_ids e
+		INNER JOIN movies m ON m.id = e.movie_id
+		WHERE e.movie_id <> $1 AND (e.source, e.external_id) IN (SELECT * FROM unnest($2::text[], $3::text[]))
+		ORDER BY e.source
+		LIMIT 1`
+
+	var conflict ExternalIDConflictError
+
+	err := tx.QueryRowContext(ctx, query, movieID, pq.Array(sources), pq.Array(externalIDs)).Scan(&conflict.Source, &conflict.ExternalID, &conflict.MovieID, &conflict.Deleted)
+	switch {
+	case err == nil:
+		return &conflict
+	case !errors.Is(err, sql.ErrNoRows):
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM movie_external_ids WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO movie_external_ids (movie_id, source, external_id)
+		SELECT $1, * FROM unnest($2::text[], $3::text[])`
+
+	_, err = tx.ExecContext(ctx, query, movieID, pq.Array(sources), pq.Array(externalIDs))
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "movie_external_ids_source_external_id_key":
+			return &ExternalIDConflictError{}
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The GetByExternalID() method returns the movie with the given ID from an external source, like IMDb. Like Get(), it returns
+// ErrRecordDeleted if the movie is in the trash.
+func (m MovieModel) GetByExternalID(source, externalID string) (*Movie, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int64
+
+	query := `SELECT movie_id FROM movie_external_ids WHERE source = $1 AND external_id = $2`
+
+	err := m.DB.QueryRowContext(ctx, query, source, externalID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return m.Get(id)
+}
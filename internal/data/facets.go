@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// FacetSafeList holds the facets that clients can ask for alongside a movie list.
+var FacetSafeList = []string{"genres", "decade", "runtime_bucket"}
+
+// A FacetCount is the number of movies in the current result set which share one value of a facet, like "drama" for genres or "1990s" for decade.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets maps each requested facet name to its counts, most common value first.
+type Facets map[string][]FacetCount
+
+// The facetQueries map holds the SELECT that computes each facet from the filtered CTE in Facets(). Every one of them returns
+// the same three columns (facet name, value and count) so that they can be glued together with UNION ALL.
+var facetQueries = map[string]string{
+	"genres": `
+		SELECT 'genres', g.name, count(*)
+		FROM filtered
+		INNER JOIN movie_genres mg ON mg.movie_id = filtered.id
+		INNER JOIN genres g ON g.id = mg.genre_id
+		GROUP BY 2`,
+	"decade": `
+		SELECT 'decade', (year / 10 * 10)::text || 's', count(*) FROM filtered GROUP BY 2`,
+	"runtime_bucket": `
+		SELECT 'runtime_bucket',
+			CASE
+				WHEN runtime < 90 THEN 'under 90 mins'
+				WHEN runtime < 120 THEN '90-119 mins'
+				WHEN runtime < 150 THEN '120-149 mins'
+				ELSE '150+ mins'
+			END,
+			count(*)
+		FROM filtered GROUP BY 2`,
+}
+
+// The Facets() method counts the movies matching the search criteria by each of the requested facets. The movies are filtered once into a
+// materialized CTE, and all of the facets are computed from it in a single query.
+// Facet counts are a nice-to-have, so rather than using a fixed timeout like our other queries, this takes a context from the caller,
+// who can decide how long they're prepared to wait before giving up on them.
+func (m MovieModel) Facets(ctx context.Context, search MovieSearch, names []string) (Facets, error) {
+	q := search.query(m.SearchConfig, m.TitleSearchConfig)
+
+	selects := make([]string, 0, len(names))
+	for _, name := range names {
+		selects = append(selects, facetQueries[name])
+	}
+
+	query := fmt.Sprintf(`
+		WITH filtered AS MATERIALIZED (
+			SELECT id, year, runtime FROM movies %s
+		)
+		%s
+		ORDER BY 1, 3 DESC, 2`, q.where, strings.Join(selects, "\n\t\tUNION ALL"))
+
+	// Facets are counted by a query of their own, at the same time as the list is fetched, and a transaction can only run one
+	// query at a time, so they always come from the connection pool rather than the model's transaction.
+	rows, err := m.pool.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Start every requested facet off with an empty slice, so that a facet with no values is returned as [] rather than left out.
+	facets := make(Facets, len(names))
+	for _, name := range names {
+		facets[name] = []FacetCount{}
+	}
+
+	for rows.Next() {
+		var name string
+		var count FacetCount
+
+		err := rows.Scan(&name, &count.Value, &count.Count)
+		if err != nil {
+			return nil, err
+		}
+
+		facets[name] = append(facets[name], count)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return facets, nil
+}
@@ -7,20 +7,30 @@ import (
 )
 
 // Add a SortSafelist field to hold the supported sort values.
+// The Cursor and Limit fields are an alternative to Page and PageSize: if either of them is set, we use keyset pagination instead,
+// and CursorKey holds the secret that cursors are signed with.
 type Filters struct {
 	Page         int
 	PageSize     int
 	Sort         string
 	SortSafeList []string
+	Cursor       string
+	Limit        int
+	CursorKey    []byte
 }
 
 // Define a new Metadata struct for holding the pagination metadata
+// When keyset pagination is used, only the page size and the next and previous cursors are filled in.
+// Fuzzy is set when a text search found nothing and the results come from a similarity search instead.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage  int    `json:"current_page,omitempty"`
+	PageSize     int    `json:"page_size,omitempty"`
+	FirstPage    int    `json:"first_page,omitempty"`
+	LastPage     int    `json:"last_page,omitempty"`
+	TotalRecords int    `json:"total_records,omitempty"`
+	NextCursor   string `json:"next_cursor,omitempty"`
+	PrevCursor   string `json:"prev_cursor,omitempty"`
+	Fuzzy        bool   `json:"fuzzy,omitempty"`
 }
 
 // Check that the client-provided Sort field matches one of the entries in our safe list
@@ -45,15 +55,64 @@ func (f Filters) sortDirection() string {
 	return "ASC"
 }
 
+// The keyset() method reports whether the client asked for cursor-based pagination rather than page numbers.
+func (f Filters) keyset() bool {
+	return f.Cursor != "" || f.Limit != 0
+}
+
+// The cursor() method decodes the client-provided cursor. It returns nil if no cursor was provided, which means we're on the first page.
+func (f Filters) cursor() (*Cursor, error) {
+	if f.Cursor == "" {
+		return nil, nil
+	}
+
+	return DecodeCursor(f.Cursor, f.CursorKey)
+}
+
+// The reverseDirection() function flips an "ASC" sort direction to "DESC" and vice versa.
+func reverseDirection(direction string) string {
+	if direction == "DESC" {
+		return "ASC"
+	}
+
+	return "DESC"
+}
+
+// The comparison() function returns the operator which selects the rows that come after a given value when sorting in the given direction.
+func comparison(direction string) string {
+	if direction == "DESC" {
+		return "<"
+	}
+
+	return ">"
+}
+
 func ValidateFilters(v *validator.Validator, f Filters) {
+	// Check that the sort parameter matches a value in the safelist.
+	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
+
+	// When paging by cursor, the page and page_size parameters are ignored.
+	if f.keyset() {
+		v.Check(f.Limit > 0, "limit", "must be greater than zero")
+		v.Check(f.Limit <= 100, "limit", "must be a maximum of 100")
+
+		// Check that the cursor hasn't been tampered with, and that it was issued for the same sort order
+		// as this request. The cursor stores a value of the sort column, so it means nothing under a different sort.
+		c, err := f.cursor()
+		if err != nil {
+			v.AddError("cursor", "must be a cursor returned by a previous request")
+		} else if c != nil {
+			v.Check(c.Sort == f.Sort, "cursor", "does not match the sort parameter")
+		}
+
+		return
+	}
+
 	// Check that the page and page_size parameters contain sensible values
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
 	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
-
-	// Check that the sort parameter matches a value in the safelist.
-	v.Check(validator.In(f.Sort, f.SortSafeList...), "sort", "invalid sort value")
 }
 
 // The calculateMetadata() function calculates the appropriate pagination metadata values given the total number of records, current page, and page size values.
@@ -0,0 +1,446 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"greenlight/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define the errors that the GenreModel can return.
+var (
+	ErrDuplicateGenre = errors.New("duplicate genre")
+	ErrUnknownGenre   = errors.New("unknown genre")
+	ErrGenreInUse     = errors.New("genre in use")
+)
+
+// The Genre struct represents one canonical genre. Aliases are alternative names ("sci-fi") that resolve to it.
+// Genre names and aliases are always stored lowercase, which is what makes "Comedy" and "comedy" the same genre.
+type Genre struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	Version   int32     `json:"version"`
+}
+
+// Define a GenreModel struct type which wraps a sql.DB connection pool.
+type GenreModel struct {
+	DB DBTX
+}
+
+// The CanonicalGenre() function normalizes a genre name or alias to the form we store it in: trimmed, lowercase,
+// and with any runs of whitespace collapsed to a single space.
+func CanonicalGenre(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func ValidateGenre(v *validator.Validator, genre *Genre) {
+	v.Check(genre.Name != "", "name", "must be provided")
+	v.Check(len(genre.Name) <= 100, "name", "must not be more than 100 bytes long")
+
+	v.Check(genre.Aliases != nil, "aliases", "must be provided")
+	v.Check(len(genre.Aliases) <= 20, "aliases", "must not contain more than 20 aliases")
+	v.Check(validator.Unique(genre.Aliases), "aliases", "must not contain duplicate values")
+
+	for _, alias := range genre.Aliases {
+		v.Check(alias != "", "aliases", "must not contain empty values")
+		v.Check(len(alias) <= 100, "aliases", "must not contain values more than 100 bytes long")
+		v.Check(alias != genre.Name, "aliases", "must not contain the genre name")
+	}
+}
+
+// The Insert() method creates a new genre along with its aliases. If the name or any alias is already taken by another genre,
+// either as a name or as an alias, it returns ErrDuplicateGenre.
+func (m GenreModel) Insert(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkGenreNamesFree(ctx, tx, 0, append([]string{genre.Name}, genre.Aliases...))
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO genres (name)
+		VALUES ($1)
+		RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, genre.Name).Scan(&genre.ID, &genre.CreatedAt, &genre.Version)
+	if err != nil {
+		return duplicateGenreError(err)
+	}
+
+	err = setGenreAliases(ctx, tx, genre.ID, genre.Aliases)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Get() method fetches a genre and its aliases by id.
+func (m GenreModel) Get(id int64) (*Genre, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT id, created_at, name, ARRAY(SELECT alias FROM genre_aliases WHERE genre_id = genres.id ORDER BY alias), version
+		FROM genres
+		WHERE id = $1`
+
+	var genre Genre
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&genre.ID,
+		&genre.CreatedAt,
+		&genre.Name,
+		pq.Array(&genre.Aliases),
+		&genre.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &genre, nil
+}
+
+// The GetAll() method returns every genre, in alphabetical order. There are only ever a few hundred genres at most,
+// so unlike movies we don't paginate them.
+func (m GenreModel) GetAll() ([]*Genre, error) {
+	query := `
+		SELECT id, created_at, name, ARRAY(SELECT alias FROM genre_aliases WHERE genre_id = genres.id ORDER BY alias), version
+		FROM genres
+		ORDER BY name ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(
+			&genre.ID,
+			&genre.CreatedAt,
+			&genre.Name,
+			pq.Array(&genre.Aliases),
+			&genre.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
+
+// The Update() method renames a genre and replaces its aliases, using the version number for optimistic locking in the same way as
+// MovieModel.Update(). Because movies refer to genres by id, a rename shows up on every movie in the genre straight away.
+func (m GenreModel) Update(genre *Genre) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = checkGenreNamesFree(ctx, tx, genre.ID, append([]string{genre.Name}, genre.Aliases...))
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE genres
+		SET name = $1, version = version + 1
+		WHERE id = $2 AND version = $3
+		RETURNING version`
+
+	err = tx.QueryRowContext(ctx, query, genre.Name, genre.ID, genre.Version).Scan(&genre.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return duplicateGenreError(err)
+		}
+	}
+
+	err = setGenreAliases(ctx, tx, genre.ID, genre.Aliases)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method deletes a genre. A genre which is still used by any movies can't simply be deleted, because that would quietly change
+// those movies, so in that case we return ErrGenreInUse unless mergeInto is the id of another genre. When it is, the movies are moved over
+// to that genre and the deleted genre's name and aliases become aliases of it, which is how misspelt duplicates like "comdey" get cleaned up.
+func (m GenreModel) Delete(id int64, mergeInto int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the genre row, so no movies can be added to it while we work.
+	var name string
+
+	err = tx.QueryRowContext(ctx, `SELECT name FROM genres WHERE id = $1 FOR UPDATE`, id).Scan(&name)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if mergeInto != 0 {
+		err = mergeGenre(ctx, tx, id, name, mergeInto)
+		if err != nil {
+			return err
+		}
+	} else {
+		var inUse bool
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_genres WHERE genre_id = $1)`, id).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			return ErrGenreInUse
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM genres WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The mergeGenre() function moves every movie in the genre with id `from` over to the genre with id `into`, and turns the old genre's
+// name and aliases into aliases of the new one. A movie which already has both genres just loses the old one.
+func mergeGenre(ctx context.Context, tx DBTX, from int64, name string, into int64) error {
+	if from == into {
+		return ErrRecordNotFound
+	}
+
+	var exists bool
+
+	err := tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM genres WHERE id = $1)`, into).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+
+	queries := []string{
+		`DELETE FROM movie_genres WHERE genre_id = $1 AND movie_id IN (SELECT movie_id FROM movie_genres WHERE genre_id = $2)`,
+		`UPDATE movie_genres SET genre_id = $2 WHERE genre_id = $1`,
+		`UPDATE genre_aliases SET genre_id = $2 WHERE genre_id = $1`,
+	}
+
+	for _, query := range queries {
+		_, err = tx.ExecContext(ctx, query, from, into)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO genre_aliases (alias, genre_id) VALUES ($1, $2) ON CONFLICT DO NOTHING`, name, into)
+	return err
+}
+
+// The Resolve() method maps the genre names provided by a client to their canonical names, matching names and aliases case-insensitively.
+// The canonical names are returned in the same order as the input. Any names that don't match a genre are returned in the unknown map instead,
+// along with up to three existing genres with similar names, so we can suggest what the client might have meant.
+func (m GenreModel) Resolve(names []string) ([]string, map[string][]string, error) {
+	query := `
+		SELECT n.name, COALESCE(g.name, a.name, '')
+		FROM unnest($1::text[]) WITH ORDINALITY AS n(name, position)
+		LEFT JOIN genres g ON lower(g.name) = n.name
+		LEFT JOIN (
+			SELECT genre_aliases.alias, genres.name FROM genre_aliases INNER JOIN genres ON genres.id = genre_aliases.genre_id
+		) a ON a.alias = n.name
+		ORDER BY n.position`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	canonical := make([]string, len(names))
+	for i, name := range names {
+		canonical[i] = CanonicalGenre(name)
+	}
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(canonical))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	resolved := []string{}
+	unknown := make(map[string][]string)
+
+	for i := 0; rows.Next(); i++ {
+		var name, match string
+
+		err := rows.Scan(&name, &match)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if match == "" {
+			unknown[names[i]] = nil
+			continue
+		}
+
+		resolved = append(resolved, match)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	for name := range unknown {
+		unknown[name], err = m.similar(ctx, CanonicalGenre(name))
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return resolved, unknown, nil
+}
+
+// The similar() method returns up to three genre names which are similar to the given name, or have an alias which is, best match first.
+func (m GenreModel) similar(ctx context.Context, name string) ([]string, error) {
+	query := `
+		SELECT g.name
+		FROM genres g
+		LEFT JOIN genre_aliases a ON a.genre_id = g.id
+		GROUP BY g.id
+		HAVING max(greatest(similarity(g.name, $1), similarity(coalesce(a.alias, ''), $1))) > 0.3
+		ORDER BY max(greatest(similarity(g.name, $1), similarity(coalesce(a.alias, ''), $1))) DESC, g.name ASC
+		LIMIT 3`
+
+	rows, err := m.DB.QueryContext(ctx, query, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+
+	for rows.Next() {
+		var name string
+
+		err := rows.Scan(&name)
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+
+	return names, rows.Err()
+}
+
+// The checkGenreNamesFree() function returns ErrDuplicateGenre if any of the names is already used as the name or an alias of a genre
+// other than the one with the given id. The unique indexes catch clashes between two names or two aliases, but not between a name and an alias.
+func checkGenreNamesFree(ctx context.Context, tx DBTX, id int64, names []string) error {
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM genres WHERE lower(name) = ANY($1) AND id <> $2
+			UNION ALL
+			SELECT 1 FROM genre_aliases WHERE alias = ANY($1) AND genre_id <> $2
+		)`
+
+	var taken bool
+
+	err := tx.QueryRowContext(ctx, query, pq.Array(names), id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+
+	if taken {
+		return ErrDuplicateGenre
+	}
+
+	return nil
+}
+
+// The setGenreAliases() function replaces the aliases for a genre.
+func setGenreAliases(ctx context.Context, tx DBTX, genreID int64, aliases []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM genre_aliases WHERE genre_id = $1`, genreID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO genre_aliases (alias, genre_id)
+		SELECT alias, $1 FROM unnest($2::text[]) AS alias`
+
+	_, err = tx.ExecContext(ctx, query, genreID, pq.Array(aliases))
+	if err != nil {
+		return duplicateGenreError(err)
+	}
+
+	return nil
+}
+
+// The duplicateGenreError() function converts a unique constraint violation from PostgreSQL into our ErrDuplicateGenre error.
+func duplicateGenreError(err error) error {
+	var pqErr *pq.Error
+
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicateGenre
+	}
+
+	return err
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 )
 
 // Define a custom ErrRecordNotFound error. We'll return this from our Get() method when looking up a movie that doesn't exist in our database
@@ -11,15 +12,65 @@ var (
 	ErrEditConflict   = errors.New("edit conflict")
 )
 
+// ErrRecordDeleted is returned when looking up a movie that has been deleted but not yet purged. It wraps ErrRecordNotFound,
+// so code that only checks for that treats a deleted movie like any other missing one.
+var ErrRecordDeleted = fmt.Errorf("%w: deleted", ErrRecordNotFound)
+
 // Create a Models struct which wraps the MovieModel.
 // We'll add other models to this, like a UserModel and PermissionModel, as our build progresses.
 type Models struct {
-	Movies MovieModel
+	Collections CollectionModel
+	Credits     CreditModel
+	Genres      GenreModel
+	Movies      MovieModel
+	MovieEvents MovieEventModel
+	People      PersonModel
+	Posters     PosterModel
+	Reviews     ReviewModel
+	Tokens      TokenModel
+	Users       UserModel
+	Watched     WatchedModel
+	Watchlist   WatchlistModel
+
+	// The connection pool, and the transaction the models are working inside, if there is one (see WithTx()).
+	db *sql.DB
+	tx *sql.Tx
 }
 
 // For ease of use, we also add a New() method which returns a Models struct containing the initialized MovieModel.
 func NewModels(db *sql.DB) Models {
 	return Models{
-		Movies: MovieModel{DB: db},
+		Collections: CollectionModel{DB: db},
+		Credits:     CreditModel{DB: db},
+		Genres:      GenreModel{DB: db},
+		Movies:      MovieModel{DB: db, SearchConfig: "simple", TitleSearchConfig: "simple", pool: db, primary: db},
+		MovieEvents: MovieEventModel{DB: db},
+		People:      PersonModel{DB: db},
+		Posters:     PosterModel{DB: db},
+		Reviews:     ReviewModel{DB: db},
+		Tokens:      TokenModel{DB: db},
+		Users:       UserModel{DB: db},
+		Watched:     WatchedModel{DB: db},
+		Watchlist:   WatchlistModel{DB: db},
+		db:          db,
 	}
 }
+
+// The withDB() method returns a copy of the models which run their queries inside the given transaction.
+func (m Models) withDB(tx *sql.Tx) Models {
+	m.Collections.DB = tx
+	m.Credits.DB = tx
+	m.Genres.DB = tx
+	m.Movies.DB = tx
+	m.MovieEvents.DB = tx
+	m.People.DB = tx
+	m.Posters.DB = tx
+	m.Reviews.DB = tx
+	m.Tokens.DB = tx
+	m.Users.DB = tx
+	m.Watched.DB = tx
+	m.Watchlist.DB = tx
+	m.tx = tx
+
+	return m
+}
@@ -3,9 +3,11 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"greenlight/internal/validator"
+	"strconv"
 	"time"
 
 	"github.com/lib/pq"
@@ -19,11 +21,73 @@ type Movie struct {
 	Runtime   Runtime   `json:"runtime,omitempty,string"` // Movie runtime (in minutes)
 	Genres    []string  `json:"genres,omitempty"`         // Slice of genres for the movie (romance, comedy, etc.)
 	Version   int32     `json:"version"`                  // The version number starts at 1 and will be incremented each time the movie information is updated
+	Relevance float32   `json:"relevance,omitempty"`      // How well the movie matches a full-text search (only set when searching with q)
+	Headline  string    `json:"headline,omitempty"`       // The title with the search terms highlighted (only set when searching with q)
+
+	AverageRating float64 `json:"average_rating,omitempty"` // The average review score, from 1 to 10 (omitted if the movie hasn't been reviewed)
+	RatingCount   int32   `json:"rating_count"`             // The number of reviews the average is based on
+
+	Poster      *Poster           `json:"poster,omitempty"`       // The movie's poster image (omitted if it doesn't have one)
+	ExternalIDs map[string]string `json:"external_ids,omitempty"` // The movie's IDs in other databases, keyed by source ("imdb", "tmdb" or "wikidata")
+
+	// When a movie is localized (see Localize()), Title holds the localized title, and the original title moves to OriginalTitle.
+	OriginalTitle string            `json:"original_title,omitempty"` // The movie's original title (only set when localized)
+	Language      string            `json:"language,omitempty"`       // The language tag of the localized title (omitted if the original title is used)
+	Titles        map[string]string `json:"titles,omitempty"`         // The movie's localized titles, keyed by BCP 47 language tag
+
+	Status      string            `json:"status"`                 // Whether the movie is announced, in production or released
+	ReleaseDate string            `json:"release_date,omitempty"` // The movie's first release date, in the format YYYY-MM-DD
+	Releases    map[string]string `json:"releases,omitempty"`     // The release date in each country, keyed by ISO 3166-1 alpha-2 country code
+
+	DeletedAt *time.Time `json:"deleted_at,omitempty"` // When the movie was deleted (only set for movies in the trash)
+}
+
+// A movie's genres live in the movie_genres join table, so wherever we'd select a genres column we use this subquery instead.
+// It returns the canonical genre names as a text array, in the order they were given for the movie.
+const movieGenresSQL = `ARRAY(
+	SELECT g.name FROM movie_genres mg INNER JOIN genres g ON g.id = mg.genre_id
+	WHERE mg.movie_id = movies.id ORDER BY mg.position)`
+
+// Unrated movies have a NULL average_rating, which we read (and sort on) as 0 so that they come after every rated movie in sort=-rating.
+const movieRatingSQL = `COALESCE(average_rating, 0), rating_count`
+
+// The stringMapColumn type scans a JSON object of strings, like the ones built by jsonb_object_agg() in movieExternalIDsSQL and
+// movieTitlesSQL, into a map. A NULL leaves the map nil.
+type stringMapColumn struct {
+	m *map[string]string
+}
+
+func (c stringMapColumn) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*c.m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(src, c.m)
+	default:
+		return fmt.Errorf("cannot scan %T into a map", src)
+	}
 }
 
+// The number of rows Export() fetches from its cursor in each round trip.
+const exportBatchSize = 500
+
 // Define a MovieModel struct type which wraps a sql.DB connection pool.
+// SearchConfig is the name of the PostgreSQL text search configuration (like "simple" or "english") used to index new movies and to parse full-text queries.
+// Movies indexed with a different configuration are moved onto it by Reindex(). TitleSearchConfig is the configuration used
+// by the title filter.
+// DB is the connection pool, or a transaction inside Models.WithTx(). The pool is kept separately for the facet counts, which
+// run at the same time as other queries and so can't share a transaction with them.
+// Replicas holds the read replicas that Replica() sends reads to, and is nil if there aren't any. Cache holds the movies
+// returned by Get(), and is nil if caching is turned off. The primary is kept separately for filling the cache (see Get()).
 type MovieModel struct {
-	DB *sql.DB
+	DB                DBTX
+	SearchConfig      string
+	TitleSearchConfig string
+	Replicas          *ReplicaSet
+	Cache             *MovieCache
+	pool              *sql.DB
+	primary           *sql.DB
 }
 
 func ValidateMovie(v *validator.Validator, movie *Movie) {
@@ -32,42 +96,103 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 
 	v.Check(movie.Year != 0, "year", "must be provided")
 	v.Check(movie.Year >= 1888, "year", "must be greater than 1888")
-	v.Check(movie.Year <= int32(time.Now().Year()), "year", "must not be in the future")
 
-	v.Check(movie.Runtime != 0, "runtime", "must be provided")
-	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+	// The runtime of a film that hasn't been released yet often isn't known, so it's only required for released movies.
+	if movie.Status == StatusReleased || movie.Runtime != 0 {
+		v.Check(movie.Runtime != 0, "runtime", "must be provided")
+		v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
+	}
 
 	v.Check(movie.Genres != nil, "genres", "must be provided")
 	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
 	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+
+	ValidateExternalIDs(v, movie.ExternalIDs)
+	ValidateTitles(v, movie.Titles)
+	validateRelease(v, movie)
 }
 
 // Add a placeholder method for inserting a new record in the movies table.
 // The Insert() method accepts a pointer to a movie struct, which should contain the data for the new record.
-func (m MovieModel) Insert(movie *Movie) error {
+// The actor is the user making the change, which is recorded in the movie's history along with every other change.
+func (m MovieModel) Insert(movie *Movie, actor *User) error {
 	// Define the SQL query for inserting a new record in the movies table and returning the system generated data.
+	// The search_config column records which text search configuration the generated search column is built with.
 	query := `
-		INSERT INTO movies (title, year, runtime, genres)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO movies (title, year, runtime, search_config, status, release_date)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, '')::date)
 		RETURNING id, created_at, version`
 
 	// Create an args slice containing the values for the placeholder parameters from the movie struct.
 	// Declaring this slice immediately next to our SQL query helps to make it nice and clear *what values are being used where* in the query.
-	args := []interface{}{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+	args := []interface{}{movie.Title, movie.Year, movie.Runtime, m.SearchConfig, movie.Status, movie.ReleaseDate}
 
 	//Create a context with a 3-second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	// The movie and its rows in movie_genres need to be written together, so we do both inside a transaction.
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Use the QueryRow() method to execute the SQL query on our connection pool,
 	// passing in the args slice as a variadic parameter and scanning the system-generated id, created_at and version values into the movie struct.
 	// Use QueryRowContext() and pass the context as the first argument
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieExternalIDs(ctx, tx, movie.ID, movie.ExternalIDs)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieTitles(ctx, tx, movie.ID, movie.Titles)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieReleases(ctx, tx, movie.ID, movie.Releases)
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieRevision(ctx, tx, movie.ID, RevisionInsert, actor, 0)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // Add a placeholder method for fetching a specific record from the movies table.
+// The movie comes from the cache if there is one. Movies that aren't cached yet are always looked up on the primary, even by
+// a model reading from a replica, since a replica that hadn't caught up with a change would otherwise put the old movie back
+// in the cache straight after the change invalidated it. Inside a transaction the cache is skipped altogether, since the
+// transaction may have changed the movie without committing.
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	if _, ok := m.DB.(*sql.DB); !ok || m.Cache == nil {
+		return m.get(id)
+	}
+
+	primary := m
+	primary.DB = m.primary
+
+	return m.Cache.get(id, primary.get)
+}
+
+// The get() method looks a movie up in the database.
+func (m MovieModel) get(id int64) (*Movie, error) {
 	// The PostgreSQL bigserial type that we're using for the movie ID starts
 	// auto-incrementing at 1 by default, so we know that no movies will have ID values less than that.
 	// To avoid making an unnecessary database call, we take a shortcut and return an ErrRecordNotFound error straight away.
@@ -76,7 +201,7 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 	}
 
 	// Define the SQL query for retrieving the movie data.
-	query := `SELECT id, created_at, title, year, runtime, genres, version FROM movies WHERE id = $1`
+	query := `SELECT id, created_at, title, year, runtime, ` + movieGenresSQL + `, version, ` + movieRatingSQL + `, ` + moviePosterSQL + `, ` + movieExternalIDsSQL + `, ` + movieTitlesSQL + `, ` + movieReleaseSQL + `, deleted_at FROM movies WHERE id = $1`
 
 	// Declare a Movie struct to hold the data returned by the query.
 	var movie Movie
@@ -105,6 +230,15 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
 		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount,
+		posterColumn{&movie.Poster},
+		stringMapColumn{&movie.ExternalIDs},
+		stringMapColumn{&movie.Titles},
+		&movie.Status,
+		&movie.ReleaseDate,
+		stringMapColumn{&movie.Releases},
+		&movie.DeletedAt,
 	)
 
 	// Handle any errors. If there was no matching movie found, Scan() will return a sql.ErrNoRows errors.
@@ -118,40 +252,68 @@ func (m MovieModel) Get(id int64) (*Movie, error) {
 		}
 	}
 
+	// A deleted movie stays in the table until it's purged, but as far as Get() is concerned it's gone. We return ErrRecordDeleted
+	// rather than ErrRecordNotFound so the caller can tell the difference if it wants to.
+	if movie.DeletedAt != nil {
+		return nil, ErrRecordDeleted
+	}
+
 	// Otherwise, return a pointer to the Movie struct.
 	return &movie, nil
 }
 
 // Add a placeholder method for updating a specific record in the movies table.
-func (m MovieModel) Update(movie *Movie) error {
+func (m MovieModel) Update(movie *Movie, actor *User) error {
+	return m.update(movie, actor, RevisionUpdate, 0)
+}
+
+// The Revert() method puts a movie back the way it was at an earlier version. The movie holds the old version's details (see
+// GetRevision()) with the current version number, so a revert is an ordinary update, and just as subject to edit conflicts.
+// It creates a new version, rather than rewriting history.
+func (m MovieModel) Revert(movie *Movie, actor *User, version int32) error {
+	return m.update(movie, actor, RevisionRevert, version)
+}
+
+// The update() method does the work for Update() and Revert(), recording the change as the given action.
+func (m MovieModel) update(movie *Movie, actor *User, action string, revertedFrom int32) error {
 	// Declare the SQL query for updating the record and returning the new version number.
 	// Add the 'AND version = $6' clause to the SQL query.
 	query := `
 		UPDATE movies 
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
+		SET title = $1, year = $2, runtime = $3, status = $4, release_date = NULLIF($5, '')::date, search_config = $8, version = version + 1
+		WHERE id = $6 AND version = $7 AND deleted_at IS NULL
 		RETURNING version`
 
-	// Create an args slice containing the values for the placeholder parameters.
+	// Create an args slice containing the values for the placeholder parameters. The movie is re-indexed with the current
+	// text search configuration too, in case it was indexed with a different one.
 	args := []interface{}{
 		movie.Title,
 		movie.Year,
 		movie.Runtime,
-		pq.Array(movie.Genres),
+		movie.Status,
+		movie.ReleaseDate,
 		movie.ID,
 		movie.Version,
+		m.SearchConfig,
 	}
 
 	// Create a context with a 3-second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	// As with Insert(), the movie row and its genres are updated in a single transaction.
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Use the QueryRow() method to execute the query, passing in the args slice as a
 	// variadic parameter and scanning the new version value into the movie struct.
 	// Execute the SQL query. If no matching row could be found, we know the movie version has changed
 	// (or the record has been deleted) and we return our custom ErrEditConflict error.
 	// Use QueryRowContext() and pass the context as the first argument
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
@@ -161,41 +323,326 @@ func (m MovieModel) Update(movie *Movie) error {
 		}
 	}
 
+	err = setMovieGenres(ctx, tx, movie.ID, movie.Genres)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieExternalIDs(ctx, tx, movie.ID, movie.ExternalIDs)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieTitles(ctx, tx, movie.ID, movie.Titles)
+	if err != nil {
+		return err
+	}
+
+	err = setMovieReleases(ctx, tx, movie.ID, movie.Releases)
+	if err != nil {
+		return err
+	}
+
+	err = recordMovieRevision(ctx, tx, movie.ID, action, actor, revertedFrom)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Other instances hear about the change from the trigger's notification, but we don't want to wait for ours.
+	m.invalidate(movie.ID)
+
+	return nil
+}
+
+// The setMovieGenres() function replaces the genres for a movie in the movie_genres table, keeping them in the order given.
+// The names must be canonical genre names (see GenreModel.Resolve()). If any of them doesn't match a genre, we return an
+// ErrUnknownGenre error rather than silently dropping it.
+func setMovieGenres(ctx context.Context, tx DBTX, movieID int64, names []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM movie_genres WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_genres (movie_id, genre_id, position)
+		SELECT $1, g.id, n.position
+		FROM unnest($2::text[]) WITH ORDINALITY AS n(name, position)
+		INNER JOIN genres g ON lower(g.name) = lower(n.name)`
+
+	result, err := tx.ExecContext(ctx, query, movieID, pq.Array(names))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != int64(len(names)) {
+		return ErrUnknownGenre
+	}
+
 	return nil
 }
 
 // Add a placeholder method for deleting a specific record from the movie table.
-func (m MovieModel) Delete(id int64) error {
+// Deleting a movie doesn't remove it from the table straight away. Instead we set its deleted_at timestamp, which hides it
+// everywhere else, and it stays in the trash (with its genres, credits, reviews and so on) until it's restored or purged.
+func (m MovieModel) Delete(id int64, actor *User) error {
+	return m.setDeleted(id, actor, true)
+}
+
+// The Restore() method takes a movie out of the trash, returning ErrRecordNotFound if there's no deleted movie with the given ID.
+func (m MovieModel) Restore(id int64, actor *User) error {
+	return m.setDeleted(id, actor, false)
+}
+
+// The setDeleted() method moves a movie into or out of the trash, and records that in the movie's history.
+func (m MovieModel) setDeleted(id int64, actor *User, deleted bool) error {
 	// Return an ErrRecordNotFound error if the movie ID is less than 1.
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 
-	// Construct the SQL query to delete the record.
-	query := `DELETE FROM movies WHERE id = $1`
-
 	// Create a context with a 3-second timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// Execute the SQL query using the Exec() method, passing in the id variable as the value for the placeholder parameter.
-	// The Exec() method returns a sql.Result object.
-	// Use ExecContext() and pass the context as the first argument
-	result, err := m.DB.ExecContext(ctx, query, id)
+	// The change and its revision are written together, so we do both inside a transaction.
+	tx, err := beginTx(ctx, m.DB, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	// Call the RowsAffected() method on the sql.Result object to get the number of rows affected by the query.
-	rowsAffected, err := result.RowsAffected()
+	// The version is incremented too, so that a client holding an old copy of the movie can't update it after it's restored
+	// without fetching it again.
+	query := `
+		UPDATE movies
+		SET deleted_at = NOW(), version = version + 1
+		WHERE id = $1 AND deleted_at IS NULL
+		RETURNING title`
+	action := RevisionDelete
+
+	if !deleted {
+		query = `
+			UPDATE movies
+			SET deleted_at = NULL, version = version + 1
+			WHERE id = $1 AND deleted_at IS NOT NULL
+			RETURNING title`
+		action = RevisionRestore
+	}
+
+	// If no row was updated, we know that the movies table didn't contain a record with the provided ID (or it was already in
+	// the state we wanted).
+	var title string
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&title)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	// A movie in the trash is taken out of any collections it's in, just as it was when deleting was for good, and the
+	// collections' owners can see the removal. Restoring the movie puts it back where it was. The UPDATE has locked the
+	// movie's row, which is what removeFromCollections() and restoreToCollections() expect.
+	if deleted {
+		err = removeFromCollections(ctx, tx, id, title)
+	} else {
+		err = restoreToCollections(ctx, tx, id)
+	}
 	if err != nil {
 		return err
 	}
 
-	// If no rows were affected, we know that the movies table didn't contain a record with the provided ID at the moment we tried to delete it.
-	// In that case we return an ErrRecordNotFound error.
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	err = recordMovieRevision(ctx, tx, id, action, actor, 0)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	m.invalidate(id)
+
+	return nil
+}
+
+// The invalidate() method removes a movie that's just been changed from the cache. Inside an outer transaction (see
+// Models.WithTx()) the commit above only released a savepoint, and invalidating now would let another request cache the
+// movie again before the change is visible. So we leave it to the trigger's notification, which PostgreSQL only sends once
+// the outer transaction commits.
+func (m MovieModel) invalidate(id int64) {
+	if _, ok := m.DB.(*sql.Tx); ok {
+		return
+	}
+
+	m.Cache.Invalidate(id)
+}
+
+// The Trash() method returns a page of the deleted movies that haven't been purged yet, most recently deleted first by default.
+func (m MovieModel) Trash(filters Filters) ([]*Movie, Metadata, error) {
+	column := filters.sortColumn()
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), movies.deleted_at, %s
+		FROM movies
+		WHERE movies.deleted_at IS NOT NULL
+		ORDER BY movies.%s %s, movies.id ASC
+		LIMIT $1 OFFSET $2`, listMovieSQL, column, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var deletedAt time.Time
+
+		movie, dest := scanListMovie()
+
+		err := rows.Scan(append([]interface{}{&totalRecords, &deletedAt}, dest...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movie.DeletedAt = &deletedAt
+		movies = append(movies, movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return movies, metadata, nil
+}
+
+// The Purge() method deletes the movies that have been in the trash for longer than the retention period for good, and returns
+// how many it deleted. Each movie is purged in its own transaction, so that purging a big trash doesn't hold locks on all of
+// it at once, and a failure part way through keeps the movies purged so far.
+func (m MovieModel) Purge(retention time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `SELECT id FROM movies WHERE deleted_at < NOW() - $1 * interval '1 second' ORDER BY deleted_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+
+	for rows.Next() {
+		var id int64
+
+		err := rows.Scan(&id)
+		if err != nil {
+			return 0, err
+		}
+
+		ids = append(ids, id)
+	}
+
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+
+	for _, id := range ids {
+		ok, err := m.purge(id, retention)
+		if err != nil {
+			return purged, err
+		}
+
+		if ok {
+			purged++
+		}
+	}
+
+	return purged, nil
+}
+
+// The purge() method hard-deletes a single movie, if it's still in the trash and old enough to purge. It reports whether it did.
+func (m MovieModel) purge(id int64, retention time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The poster's blobs are queued for garbage collection in the same transaction as the movie is deleted.
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	// Lock the movie's row first. It may have been restored, or purged by someone else, since Purge() looked for it, in which
+	// case we leave it alone. It was taken out of its collections when it went into the trash, and it can't be added to any
+	// while it's there, so there's nothing to do for those.
+	query := `
+		SELECT id FROM movies
+		WHERE id = $1 AND deleted_at < NOW() - $2 * interval '1 second'
+		FOR UPDATE`
+
+	err = tx.QueryRowContext(ctx, query, id, retention.Seconds()).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	// Queue the blobs of the movie's poster for garbage collection, since nothing will refer to them once it's gone.
+	err = orphanMoviePoster(ctx, tx, id)
+	if err != nil {
+		return false, err
+	}
+
+	// Its genres, credits, reviews, poster and list entries are deleted along with it by the ON DELETE CASCADE foreign keys.
+	_, err = tx.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// The checkMovieNotDeleted() function locks a movie against being deleted or purged for the rest of the transaction, and returns
+// ErrRecordNotFound if it doesn't exist or is already in the trash. Setting deleted_at is an ordinary UPDATE, so FOR KEY SHARE
+// wouldn't block it; FOR SHARE does.
+func checkMovieNotDeleted(ctx context.Context, tx DBTX, movieID int64) error {
+	err := tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR SHARE`, movieID).Scan(&movieID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
 	return nil
@@ -204,7 +651,45 @@ func (m MovieModel) Delete(id int64) error {
 // Create a new GetAll() method which returns a slice of movies.
 // Although we're not using them right now, we've set this up to accept the various filter parameters as arguments
 // Update the function signature to return a Metadata struct
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// The search parameter holds the optional criteria (title, genres, year range and so on) that narrow down the list.
+func (m MovieModel) GetAll(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	movies, metadata, err := m.getAll(search, filters)
+	if err != nil || len(movies) > 0 || search.Fuzzy || (search.Query == "" && search.Title == "") {
+		return movies, metadata, err
+	}
+
+	// Full-text search only matches whole (or prefixed) words, so a misspelt title like "Godfater" finds nothing.
+	// When that happens, we check whether the full-text search matches anything at all (rather than this page just being past the end)
+	// and if it doesn't, we try again with trigram similarity instead. The Fuzzy flag in the metadata tells the client that's what happened.
+	found, err := m.exists(search)
+	if err != nil || found {
+		return movies, metadata, err
+	}
+
+	search.Fuzzy = true
+
+	movies, metadata, err = m.getAll(search, filters)
+	metadata.Fuzzy = len(movies) > 0
+
+	return movies, metadata, err
+}
+
+// The getAll() method runs the list query, using keyset pagination if the client asked for it, or page numbers otherwise.
+func (m MovieModel) getAll(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	// If the client asked for cursor-based pagination, hand over to getAllByCursor() instead.
+	if filters.keyset() {
+		return m.getAllByCursor(search, filters)
+	}
+
+	return m.getAllByOffset(search, filters)
+}
+
+// The getAllByOffset() method pages through the list with LIMIT and OFFSET, and counts the total number of matching records.
+func (m MovieModel) getAllByOffset(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	// Build the WHERE clause and the rank and headline expressions from whichever search criteria were provided.
+	q := search.query(m.SearchConfig, m.TitleSearchConfig)
+	column := filters.sortColumn()
+
 	// Construct the SQL query to retrieve all movie records.
 	// Update the SQL query to include the filter conditions
 	// Use full-text search for the title filter
@@ -212,22 +697,22 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// Importantly notice that we also include a secondary sort on the movie ID to ensure a consistent ordering.
 	// Update the SQL query to include the LIMIT and OFFSET clauses with placeholder parameter values
 	// Update the SQL query to include the window function which counts the total filtered records
+	// Only the conditions for the criteria that were actually provided are included, which keeps the query simple enough for the
+	// planner to use the title and genres indexes. Notice how the LIMIT and OFFSET values also get their placeholders from the where clause.
 	query := fmt.Sprintf(`
-		SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version 
+		SELECT count(*) OVER(), id, created_at, title, year, runtime, %s, version, %s, %s, %s, %s, %s, %s, %s
 		FROM movies 
-		WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-		AND (genres @> $2 OR $2 = '{}') 
+		%s
 		ORDER BY %s %s, id ASC
-		LIMIT $3 OFFSET $4
-	`, filters.sortColumn(), filters.sortDirection())
+		LIMIT %s OFFSET %s
+	`, movieGenresSQL, movieRatingSQL, moviePosterSQL, movieExternalIDsSQL, movieTitlesSQL, movieReleaseSQL, q.rank, q.headline, q.where, q.sortExpression(column), movieSortDirection(column, filters.sortDirection()), q.arg(filters.limit()), q.arg(filters.offset()))
 
 	// Create a context with a 3-second timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	// As our SQL query now has quite a few placeholder parameters, let's collect the
-	// values for the placeholders in a slice. Notice here how we call the limit() and offset() methods on the Filters struct to get the appropriate values for the LIMIT and OFFSET clauses.
-	args := []interface{}{title, pq.Array(genres), filters.limit(), filters.offset()}
+	// The where clause has collected the values for all of the placeholder parameters, in order.
+	args := q.args
 
 	// Use QueryContext() to execute the query. This returns a sql.Rows result set containing the result
 	// Pass the title and genres as the placeholder parameter values
@@ -261,6 +746,16 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			posterColumn{&movie.Poster},
+			stringMapColumn{&movie.ExternalIDs},
+			stringMapColumn{&movie.Titles},
+			&movie.Status,
+			&movie.ReleaseDate,
+			stringMapColumn{&movie.Releases},
+			&movie.Relevance,
+			&movie.Headline,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
@@ -282,3 +777,276 @@ func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*M
 	// Include the metadata struct when returning.
 	return movies, metadata, nil
 }
+
+// The exists() method reports whether any movies at all match the search criteria.
+func (m MovieModel) exists(search MovieSearch) (bool, error) {
+	q := search.query(m.SearchConfig, m.TitleSearchConfig)
+
+	query := fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM movies %s)`, q.where)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var found bool
+
+	err := m.DB.QueryRowContext(ctx, query, q.args...).Scan(&found)
+
+	return found, err
+}
+
+// The Export() method streams every movie matching the search criteria to the fn callback, one row at a time and in id order.
+// Rather than loading the whole result set into memory like GetAll() does, we open a server-side cursor inside a read-only transaction
+// and FETCH from it in fixed-size batches, so memory use stays bounded no matter how many rows match.
+// The parent context should be the request context, so that the cursor is closed and the transaction rolled back as soon as the client goes away.
+// If fn returns an error, the export stops and that error is returned.
+func (m MovieModel) Export(ctx context.Context, search MovieSearch, fn func(*Movie) error) error {
+	// A cursor declared without WITH HOLD only lives for the duration of the transaction it was declared in.
+	tx, err := beginTx(ctx, m.DB, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+
+	// Rolling back a read-only transaction is harmless, and it also closes the cursor for us.
+	defer tx.Rollback()
+
+	// Use the same filter conditions as GetAll(), but order by the primary key so the output is stable and the planner can walk the index.
+	q := search.query(m.SearchConfig, m.TitleSearchConfig)
+
+	query := fmt.Sprintf(`
+		DECLARE movies_export NO SCROLL CURSOR FOR
+		SELECT id, created_at, title, year, runtime, %s, version
+		FROM movies
+		%s
+		ORDER BY id ASC`, movieGenresSQL, q.where)
+
+	_, err = tx.ExecContext(ctx, query, q.args...)
+	if err != nil {
+		return err
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM movies_export", exportBatchSize)
+
+	for {
+		movies, err := m.exportBatch(ctx, tx, fetch)
+		if err != nil {
+			return err
+		}
+
+		// The batch is handed to fn outside the FETCH's timeout, since fn is usually writing to a slow client, and that
+		// shouldn't count against the time allowed for the query. It still stops when the request's context is cancelled.
+		for _, movie := range movies {
+			err = fn(movie)
+			if err != nil {
+				return err
+			}
+		}
+
+		// A short batch means the cursor has been exhausted.
+		if len(movies) < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+// The exportBatch() helper fetches the next batch of rows from the export cursor.
+// Each FETCH gets its own 3-second timeout, the same as our other queries, so a single slow batch can't hold the connection forever.
+// The rows are read into a slice and closed before returning, so that the timeout only covers the FETCH itself.
+func (m MovieModel) exportBatch(ctx context.Context, tx DBTX, fetch string) ([]*Movie, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	movies := make([]*Movie, 0, exportBatchSize)
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return movies, nil
+}
+
+// The getAllByCursor() method is the keyset pagination version of GetAll(). Instead of counting and skipping rows with OFFSET,
+// it seeks straight to the row after (or before) the one identified by the cursor using the sort column and id, so deep pages are as
+// cheap as the first one and rows inserted while a client is paging don't shift the results under it.
+func (m MovieModel) getAllByCursor(search MovieSearch, filters Filters) ([]*Movie, Metadata, error) {
+	cursor, err := filters.cursor()
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	q := search.query(m.SearchConfig, m.TitleSearchConfig)
+
+	column := filters.sortColumn()
+	direction := movieSortDirection(column, filters.sortDirection())
+	idDirection := "ASC"
+
+	// To read the page before the cursor, we flip both sort directions so the rows nearest the cursor come first.
+	// We'll reverse the slice back into the normal order once we've read it.
+	backward := cursor != nil && cursor.Direction == cursorPrev
+	if backward {
+		direction = reverseDirection(direction)
+		idDirection = reverseDirection(idDirection)
+	}
+
+	sortExpression := q.sortExpression(column)
+
+	// Only rows strictly after the cursor position in the (sort column, id) ordering are wanted. Because the sort column
+	// and id can be ordered in different directions, we can't use a row comparison here and spell out the condition instead.
+	if cursor != nil {
+		seek := fmt.Sprintf("(%[1]s %[2]s %%[1]s OR (%[1]s = %%[1]s AND id %[3]s %%[2]s))", sortExpression, comparison(direction), comparison(idDirection))
+		q.add(seek, cursor.Value, cursor.ID)
+	}
+
+	// We fetch one more row than the limit, so we know whether there's another page after this one.
+	query := fmt.Sprintf(`
+		SELECT id, created_at, title, year, runtime, %s, version, %s, %s, %s, %s, %s, %s, %s
+		FROM movies
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT %s
+	`, movieGenresSQL, movieRatingSQL, moviePosterSQL, movieExternalIDsSQL, movieTitlesSQL, movieReleaseSQL, q.rank, q.headline, q.where, sortExpression, direction, idDirection, q.arg(filters.Limit+1))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	movies := []*Movie{}
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			posterColumn{&movie.Poster},
+			stringMapColumn{&movie.ExternalIDs},
+			stringMapColumn{&movie.Titles},
+			&movie.Status,
+			&movie.ReleaseDate,
+			stringMapColumn{&movie.Releases},
+			&movie.Relevance,
+			&movie.Headline,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		movies = append(movies, &movie)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// If we got the extra row there's more to read in the direction we were going. Drop it from the results.
+	more := len(movies) > filters.Limit
+	if more {
+		movies = movies[:filters.Limit]
+	}
+
+	if backward {
+		for i, j := 0, len(movies)-1; i < j; i, j = i+1, j-1 {
+			movies[i], movies[j] = movies[j], movies[i]
+		}
+	}
+
+	metadata := Metadata{PageSize: filters.Limit}
+
+	if len(movies) == 0 {
+		return movies, metadata, nil
+	}
+
+	// Work out which neighbouring pages exist. Going forwards, there's a previous page whenever we started from a cursor;
+	// going backwards, there's always a next page (the one we came from).
+	hasNext, hasPrev := more, cursor != nil
+	if backward {
+		hasNext, hasPrev = true, more
+	}
+
+	if hasNext {
+		last := movies[len(movies)-1]
+		metadata.NextCursor = Cursor{Sort: filters.Sort, Value: last.sortValue(column), ID: last.ID, Direction: cursorNext}.Encode(filters.CursorKey)
+	}
+
+	if hasPrev {
+		first := movies[0]
+		metadata.PrevCursor = Cursor{Sort: filters.Sort, Value: first.sortValue(column), ID: first.ID, Direction: cursorPrev}.Encode(filters.CursorKey)
+	}
+
+	return movies, metadata, nil
+}
+
+// The movieSortDirection() function returns the SQL sort direction for a movie sort column. For most columns that's the direction given
+// by the sort parameter, but "relevance" means best match first, which is a descending sort on the rank.
+func movieSortDirection(column, direction string) string {
+	if column == "relevance" {
+		return reverseDirection(direction)
+	}
+
+	return direction
+}
+
+// The sortValue() method returns the value of the given sort column for a movie, formatted as a string for use in a Cursor.
+// Any column that can appear in a SortSafeList needs a case here.
+func (movie *Movie) sortValue(column string) string {
+	switch column {
+	case "id":
+		return strconv.FormatInt(movie.ID, 10)
+	case "title":
+		return movie.Title
+	case "year":
+		return strconv.FormatInt(int64(movie.Year), 10)
+	case "runtime":
+		return strconv.FormatInt(int64(movie.Runtime), 10)
+	case "rating":
+		// The average rating is stored with two decimal places, so this formats it exactly.
+		return strconv.FormatFloat(movie.AverageRating, 'f', 2, 64)
+	case "release_date":
+		// Undated movies sort as if they were released at the end of time (see sortExpression()).
+		if movie.ReleaseDate == "" {
+			return "infinity"
+		}
+		return movie.ReleaseDate
+	case "relevance":
+		// Format the rank with the smallest number of digits that still parse back to exactly the same float32 (PostgreSQL's real type).
+		return strconv.FormatFloat(float64(movie.Relevance), 'g', -1, 32)
+	}
+
+	panic("unsupported sort column: " + column)
+}
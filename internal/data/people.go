@@ -0,0 +1,383 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define the errors that the PersonModel and CreditModel can return.
+var (
+	ErrPersonHasCredits = errors.New("person has credits")
+	ErrDuplicateCredit  = errors.New("duplicate credit")
+)
+
+// CreditRoles holds the roles that a person can be credited with on a movie.
+var CreditRoles = []string{"director", "writer", "actor"}
+
+// The Person struct represents someone who worked on one or more movies.
+type Person struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"-"`
+	Name      string    `json:"name"`
+	BirthYear int32     `json:"birth_year,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// A Credit links a person to a movie in a particular role. Character and BillingOrder only apply to actors:
+// the billing order is their position in the cast list, starting at 1.
+type Credit struct {
+	ID           int64  `json:"id"`
+	MovieID      int64  `json:"movie_id"`
+	MovieTitle   string `json:"movie_title,omitempty"`
+	PersonID     int64  `json:"person_id"`
+	PersonName   string `json:"person_name,omitempty"`
+	Role         string `json:"role"`
+	Character    string `json:"character,omitempty"`
+	BillingOrder int32  `json:"billing_order,omitempty"`
+}
+
+// Define a PersonModel struct type which wraps a sql.DB connection pool.
+type PersonModel struct {
+	DB DBTX
+}
+
+// Define a CreditModel struct type which wraps a sql.DB connection pool.
+type CreditModel struct {
+	DB DBTX
+}
+
+func ValidatePerson(v *validator.Validator, person *Person) {
+	v.Check(person.Name != "", "name", "must be provided")
+	v.Check(len(person.Name) <= 500, "name", "must not be more than 500 bytes long")
+
+	if person.BirthYear != 0 {
+		v.Check(person.BirthYear >= 1800, "birth_year", "must be greater than 1800")
+		v.Check(person.BirthYear <= int32(time.Now().Year()), "birth_year", "must not be in the future")
+	}
+}
+
+func ValidateCredit(v *validator.Validator, credit *Credit) {
+	v.Check(credit.PersonID > 0, "person_id", "must be provided")
+
+	v.Check(credit.Role != "", "role", "must be provided")
+	v.Check(validator.In(credit.Role, CreditRoles...), "role", "must be one of director, writer or actor")
+
+	v.Check(len(credit.Character) <= 500, "character", "must not be more than 500 bytes long")
+	v.Check(credit.BillingOrder >= 0, "billing_order", "must be a positive integer")
+
+	// Only actors play characters and appear in the billing.
+	if credit.Role != "actor" {
+		v.Check(credit.Character == "", "character", "must only be provided for actors")
+		v.Check(credit.BillingOrder == 0, "billing_order", "must only be provided for actors")
+	}
+}
+
+func (m PersonModel) Insert(person *Person) error {
+	query := `
+		INSERT INTO people (name, birth_year)
+		VALUES ($1, $2)
+		RETURNING id, created_at, version`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, person.Name, nullInt32(person.BirthYear)).Scan(&person.ID, &person.CreatedAt, &person.Version)
+}
+
+func (m PersonModel) Get(id int64) (*Person, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, COALESCE(birth_year, 0), version FROM people WHERE id = $1`
+
+	var person Person
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(&person.ID, &person.CreatedAt, &person.Name, &person.BirthYear, &person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &person, nil
+}
+
+// The GetAll() method returns a page of people, optionally filtered by a case-insensitive substring of their name.
+// It uses the same Filters and Metadata as MovieModel.GetAll().
+func (m PersonModel) GetAll(name string, filters Filters) ([]*Person, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), id, created_at, name, COALESCE(birth_year, 0), version
+		FROM people
+		WHERE (name ILIKE '%%' || $1 || '%%' OR $1 = '')
+		ORDER BY %s %s, id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	// The name is escaped, so that a % or _ in it matches itself rather than acting as a wildcard.
+	rows, err := m.DB.QueryContext(ctx, query, escapeLike(name), filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	people := []*Person{}
+
+	for rows.Next() {
+		var person Person
+
+		err := rows.Scan(&totalRecords, &person.ID, &person.CreatedAt, &person.Name, &person.BirthYear, &person.Version)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		people = append(people, &person)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return people, metadata, nil
+}
+
+// The Update() method uses the version number for optimistic locking, in the same way as MovieModel.Update().
+func (m PersonModel) Update(person *Person) error {
+	query := `
+		UPDATE people
+		SET name = $1, birth_year = $2, version = version + 1
+		WHERE id = $3 AND version = $4
+		RETURNING version`
+
+	args := []interface{}{person.Name, nullInt32(person.BirthYear), person.ID, person.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&person.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delete() method deletes a person. Deleting someone who is credited on any movies would change those movies, so unless cascade
+// is true we refuse with ErrPersonHasCredits. When cascade is true, their credits are deleted along with them.
+func (m PersonModel) Delete(id int64, cascade bool) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the person row first, so nobody can add a credit for them between our check and the delete.
+	err = tx.QueryRowContext(ctx, `SELECT id FROM people WHERE id = $1 FOR UPDATE`, id).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	if cascade {
+		_, err = tx.ExecContext(ctx, `DELETE FROM movie_credits WHERE person_id = $1`, id)
+		if err != nil {
+			return err
+		}
+	} else {
+		var hasCredits bool
+
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movie_credits WHERE person_id = $1)`, id).Scan(&hasCredits)
+		if err != nil {
+			return err
+		}
+
+		if hasCredits {
+			return ErrPersonHasCredits
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM people WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Insert() method adds a credit to a movie. If the movie or person doesn't exist we return ErrRecordNotFound, and if the person
+// already has the same role (and character) on the movie we return ErrDuplicateCredit.
+func (m CreditModel) Insert(credit *Credit) error {
+	query := `
+		INSERT INTO movie_credits (movie_id, person_id, role, character, billing_order)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`
+
+	args := []interface{}{credit.MovieID, credit.PersonID, credit.Role, nullString(credit.Character), nullInt32(credit.BillingOrder)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&credit.ID)
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23503": // foreign_key_violation
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23505": // unique_violation
+			return ErrDuplicateCredit
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Delete() method removes a credit from a movie.
+func (m CreditModel) Delete(movieID, id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `DELETE FROM movie_credits WHERE id = $1 AND movie_id = $2`, id, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// The GetForMovie() method returns the credits for a movie: directors first, then writers, then the cast in billing order.
+func (m CreditModel) GetForMovie(movieID int64) ([]*Credit, error) {
+	query := `
+		SELECT c.id, c.movie_id, c.person_id, p.name, c.role, COALESCE(c.character, ''), COALESCE(c.billing_order, 0)
+		FROM movie_credits c
+		INNER JOIN people p ON p.id = c.person_id
+		WHERE c.movie_id = $1
+		ORDER BY array_position(ARRAY['director', 'writer', 'actor'], c.role), c.billing_order ASC NULLS LAST, p.name ASC, c.id ASC`
+
+	return m.query(query, movieID)
+}
+
+// The GetForPerson() method returns every credit a person has, newest movies first.
+func (m CreditModel) GetForPerson(personID int64) ([]*Credit, error) {
+	query := `
+		SELECT c.id, c.movie_id, c.person_id, mv.title, c.role, COALESCE(c.character, ''), COALESCE(c.billing_order, 0)
+		FROM movie_credits c
+		INNER JOIN movies mv ON mv.id = c.movie_id
+		WHERE c.person_id = $1 AND mv.deleted_at IS NULL
+		ORDER BY mv.year DESC, mv.title ASC, c.id ASC`
+
+	credits, err := m.query(query, personID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The fourth column holds the movie title for this query, rather than the person's name.
+	for _, credit := range credits {
+		credit.MovieTitle, credit.PersonName = credit.PersonName, ""
+	}
+
+	return credits, nil
+}
+
+// The query() method runs a query returning credit rows and scans them into a slice.
+func (m CreditModel) query(query string, id int64) ([]*Credit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		var credit Credit
+
+		err := rows.Scan(
+			&credit.ID,
+			&credit.MovieID,
+			&credit.PersonID,
+			&credit.PersonName,
+			&credit.Role,
+			&credit.Character,
+			&credit.BillingOrder,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// The nullInt32() and nullString() helpers convert an optional value to NULL when it holds the zero value, so it can be stored in a nullable column.
+func nullInt32(i int32) sql.NullInt32 {
+	return sql.NullInt32{Int32: i, Valid: i != 0}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// The escapeLike() function escapes the LIKE wildcards (% and _) in a string, and the backslash that escapes them, so that
+// the string only matches itself in a LIKE or ILIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
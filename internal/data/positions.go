@@ -0,0 +1,125 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// An orderedList describes a table holding user-ordered lists of movies, like watchlists and collections. Each row belongs to
+// the list identified by the scope column, and has a 1-based position within it. Positions are kept contiguous, so the list
+// always runs from 1 to its length with no gaps.
+// The table's uniqueness constraint on (scope, position) must be DEFERRABLE INITIALLY DEFERRED, because shifting items up or
+// down makes positions overlap until the statement that moves the item itself. The methods all work inside a transaction, and
+// the caller is responsible for locking the list (by locking the row that owns it) before using them.
+type orderedList struct {
+	table string // The table name, like "watchlist_items"
+	scope string // The column identifying which list a row belongs to, like "user_id"
+	item  string // The column identifying the item, like "movie_id"
+}
+
+// The length() method returns the number of items in a list.
+func (l orderedList) length(ctx context.Context, tx DBTX, scopeID int64) (int32, error) {
+	var length int32
+
+	query := fmt.Sprintf(`SELECT count(*) FROM %s WHERE %s = $1`, l.table, l.scope)
+
+	err := tx.QueryRowContext(ctx, query, scopeID).Scan(&length)
+
+	return length, err
+}
+
+// The makeRoom() method works out where a new item will go, and moves the items at and after that position down one place to make
+// room for it. A position of 0, or one past the end of the list, means the end of the list. It returns the position the new item
+// should be inserted with.
+func (l orderedList) makeRoom(ctx context.Context, tx DBTX, scopeID int64, position int32) (int32, error) {
+	length, err := l.length(ctx, tx, scopeID)
+	if err != nil {
+		return 0, err
+	}
+
+	if position < 1 || position > length+1 {
+		return length + 1, nil
+	}
+
+	query := fmt.Sprintf(`UPDATE %s SET position = position + 1 WHERE %s = $1 AND position >= $2`, l.table, l.scope)
+
+	_, err = tx.ExecContext(ctx, query, scopeID, position)
+
+	return position, err
+}
+
+// The move() method moves an item to a new position, shifting the items in between up or down one place. Positions past the end
+// of the list move the item to the end. It returns the item's final position, or ErrRecordNotFound if the item isn't in the list.
+func (l orderedList) move(ctx context.Context, tx DBTX, scopeID, itemID int64, position int32) (int32, error) {
+	length, err := l.length(ctx, tx, scopeID)
+	if err != nil {
+		return 0, err
+	}
+
+	var current int32
+
+	query := fmt.Sprintf(`SELECT position FROM %s WHERE %s = $1 AND %s = $2`, l.table, l.scope, l.item)
+
+	err = tx.QueryRowContext(ctx, query, scopeID, itemID).Scan(&current)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	if position > length {
+		position = length
+	}
+
+	switch {
+	case position < current:
+		query = fmt.Sprintf(`UPDATE %s SET position = position + 1 WHERE %s = $1 AND position >= $2 AND position < $3`, l.table, l.scope)
+		_, err = tx.ExecContext(ctx, query, scopeID, position, current)
+	case position > current:
+		query = fmt.Sprintf(`UPDATE %s SET position = position - 1 WHERE %s = $1 AND position > $2 AND position <= $3`, l.table, l.scope)
+		_, err = tx.ExecContext(ctx, query, scopeID, current, position)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	query = fmt.Sprintf(`UPDATE %s SET position = $1 WHERE %s = $2 AND %s = $3`, l.table, l.scope, l.item)
+
+	_, err = tx.ExecContext(ctx, query, position, scopeID, itemID)
+
+	return position, err
+}
+
+// The remove() method deletes an item from a list and closes the gap it leaves behind. It returns ErrRecordNotFound if the item
+// isn't in the list.
+func (l orderedList) remove(ctx context.Context, tx DBTX, scopeID, itemID int64) error {
+	var position int32
+
+	query := fmt.Sprintf(`DELETE FROM %s WHERE %s = $1 AND %s = $2 RETURNING position`, l.table, l.scope, l.item)
+
+	err := tx.QueryRowContext(ctx, query, scopeID, itemID).Scan(&position)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return l.closeGap(ctx, tx, scopeID, position)
+}
+
+// The closeGap() method moves every item after a removed position up one place.
+func (l orderedList) closeGap(ctx context.Context, tx DBTX, scopeID int64, position int32) error {
+	query := fmt.Sprintf(`UPDATE %s SET position = position - 1 WHERE %s = $1 AND position > $2`, l.table, l.scope)
+
+	_, err := tx.ExecContext(ctx, query, scopeID, position)
+
+	return err
+}
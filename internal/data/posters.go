@@ -0,0 +1,241 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// A Poster describes a movie's poster image. The original upload is kept alongside thumbnails in several sizes, and URLs maps
+// each size name ("original", "small", "medium" and "large") to where it can be downloaded from.
+type Poster struct {
+	Width  int               `json:"width"`  // The width of the original image, in pixels
+	Height int               `json:"height"` // The height of the original image, in pixels
+	URLs   map[string]string `json:"urls"`
+}
+
+// The Value() method lets a Poster be written to a jsonb column.
+func (p Poster) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+// A movie's poster is selected with this subquery, which returns NULL if the movie doesn't have one.
+const moviePosterSQL = `(SELECT poster FROM movie_posters WHERE movie_id = movies.id)`
+
+// The posterColumn type scans the result of moviePosterSQL into a Movie's Poster field, leaving it nil when there's no poster.
+type posterColumn struct {
+	poster **Poster
+}
+
+func (c posterColumn) Scan(src interface{}) error {
+	switch src := src.(type) {
+	case nil:
+		*c.poster = nil
+		return nil
+	case []byte:
+		var poster Poster
+		if err := json.Unmarshal(src, &poster); err != nil {
+			return err
+		}
+		*c.poster = &poster
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into a poster", src)
+	}
+}
+
+// Define a PosterModel struct type which wraps a sql.DB connection pool.
+type PosterModel struct {
+	DB DBTX
+}
+
+// Blobs are written to the blob store before the database knows about them, so an upload that fails half way (or a server that
+// crashes during one) could leave blobs behind that nothing refers to. To avoid that, an upload first reserves its keys by
+// recording them as orphaned, and Set() takes them back off the list once the poster is saved. The garbage collector only
+// deletes orphans older than a grace period, which gives uploads in progress time to finish.
+
+// The Reserve() method records the keys an upload is about to write as orphaned.
+func (m PosterModel) Reserve(keys []string) error {
+	query := `
+		INSERT INTO orphaned_blobs (key)
+		SELECT unnest($1::text[])
+		ON CONFLICT (key) DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(keys))
+
+	return err
+}
+
+// The Set() method saves a movie's poster along with the keys of the blobs it's stored in, replacing any existing poster.
+// The old poster's blobs are queued for garbage collection in the same transaction. If the movie doesn't exist, we return
+// ErrRecordNotFound, and the new blobs stay queued for collection.
+func (m PosterModel) Set(movieID int64, poster *Poster, keys []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the movie's row until we're done. That stops the movie being deleted in the meantime (MovieModel.Delete() queues
+	// the poster's blobs for collection, so it mustn't miss the ones we're about to save), and stops two uploads for the same
+	// movie both replacing the same old poster, which would leave one of their new sets of blobs uncollected.
+	var id int64
+
+	err = tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 AND deleted_at IS NULL FOR NO KEY UPDATE`, movieID).Scan(&id)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	var oldKeys []string
+
+	err = tx.QueryRowContext(ctx, `SELECT blob_keys FROM movie_posters WHERE movie_id = $1`, movieID).Scan(pq.Array(&oldKeys))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	err = orphanBlobs(ctx, tx, oldKeys)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM orphaned_blobs WHERE key = ANY($1)`, pq.Array(keys))
+	if err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO movie_posters (movie_id, poster, blob_keys)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (movie_id) DO UPDATE
+		SET poster = EXCLUDED.poster, blob_keys = EXCLUDED.blob_keys, uploaded_at = NOW()`
+
+	_, err = tx.ExecContext(ctx, query, movieID, poster, pq.Array(keys))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method removes a movie's poster and queues its blobs for garbage collection. If the movie doesn't have a
+// poster, we return ErrRecordNotFound.
+func (m PosterModel) Delete(movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var keys []string
+
+	err = tx.QueryRowContext(ctx, `DELETE FROM movie_posters WHERE movie_id = $1 RETURNING blob_keys`, movieID).Scan(pq.Array(&keys))
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = orphanBlobs(ctx, tx, keys)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Orphans() method returns up to limit keys of blobs that have been orphaned for longer than the grace period, oldest first.
+func (m PosterModel) Orphans(grace time.Duration, limit int) ([]string, error) {
+	query := `
+		SELECT key FROM orphaned_blobs
+		WHERE created_at < NOW() - make_interval(secs => $1)
+		ORDER BY created_at ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, grace.Seconds(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+
+	for rows.Next() {
+		var key string
+
+		err := rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// The ForgetOrphans() method takes keys off the orphaned list, once their blobs have been deleted from the blob store.
+func (m PosterModel) ForgetOrphans(keys []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM orphaned_blobs WHERE key = ANY($1)`, pq.Array(keys))
+
+	return err
+}
+
+// The orphanBlobs() function queues blobs for garbage collection.
+func orphanBlobs(ctx context.Context, tx DBTX, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO orphaned_blobs (key)
+		SELECT unnest($1::text[])
+		ON CONFLICT (key) DO UPDATE SET created_at = NOW()`
+
+	_, err := tx.ExecContext(ctx, query, pq.Array(keys))
+
+	return err
+}
+
+// The orphanMoviePoster() function is called by MovieModel.Purge() to queue the blobs of a movie's poster for garbage
+// collection. The poster's row itself goes when the movie is deleted, through its ON DELETE CASCADE foreign key. A movie in
+// the trash keeps its poster, so that restoring it brings the poster back too, and its blobs are only collected once it's
+// purged.
+func orphanMoviePoster(ctx context.Context, tx DBTX, movieID int64) error {
+	query := `
+		INSERT INTO orphaned_blobs (key)
+		SELECT unnest(blob_keys) FROM movie_posters WHERE movie_id = $1
+		ON CONFLICT (key) DO UPDATE SET created_at = NOW()`
+
+	_, err := tx.ExecContext(ctx, query, movieID)
+
+	return err
+}
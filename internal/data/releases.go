@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"greenlight/internal/validator"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The statuses a movie can have. Only released movies have to be dated in the past.
+const (
+	StatusAnnounced    = "announced"
+	StatusInProduction = "in_production"
+	StatusReleased     = "released"
+)
+
+var MovieStatuses = []string{StatusAnnounced, StatusInProduction, StatusReleased}
+
+// Announced films can be dated up to this many years ahead.
+const maxYearsAhead = 10
+
+// CountryRX matches an ISO 3166-1 alpha-2 country code, like "GB" or "US".
+var CountryRX = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// A movie's status and release dates are selected with these columns. The per-country release dates come back as a
+// JSON object keyed by country code, or NULL if there aren't any.
+const movieReleaseSQL = `movies.status, COALESCE(to_char(movies.release_date, 'YYYY-MM-DD'), ''),
+	(SELECT jsonb_object_agg(country, to_char(release_date, 'YYYY-MM-DD')) FROM movie_releases WHERE movie_id = movies.id)`
+
+// The validateRelease() function checks a movie's status, year and release dates. Released movies can't be dated in the
+// future, but announced films and those in production can be, by up to maxYearsAhead years.
+func validateRelease(v *validator.Validator, movie *Movie) {
+	v.Check(validator.In(movie.Status, MovieStatuses...), "status", "must be one of announced, in_production or released")
+
+	now := time.Now()
+
+	if movie.Status == StatusReleased {
+		v.Check(movie.Year <= int32(now.Year()), "year", "must not be in the future")
+	} else {
+		v.Check(movie.Year <= int32(now.Year()+maxYearsAhead), "year", fmt.Sprintf("must not be more than %d years in the future", maxYearsAhead))
+	}
+
+	if movie.ReleaseDate != "" {
+		releaseDate, err := time.Parse(time.DateOnly, movie.ReleaseDate)
+
+		v.Check(err == nil, "release_date", "must be a date in the format YYYY-MM-DD")
+		if err == nil {
+			v.Check(int32(releaseDate.Year()) == movie.Year, "release_date", "must be in the movie's year")
+
+			if movie.Status == StatusReleased {
+				v.Check(movie.ReleaseDate <= now.AddDate(0, 0, 1).Format(time.DateOnly), "release_date", "must not be in the future for a released movie")
+			}
+		}
+	}
+
+	v.Check(len(movie.Releases) <= 250, "releases", "must not contain more than 250 countries")
+
+	for country, date := range movie.Releases {
+		v.Check(CountryRX.MatchString(country), "releases", fmt.Sprintf("contains an invalid country code %q", country))
+
+		_, err := time.Parse(time.DateOnly, date)
+		v.Check(err == nil, "releases", "must only contain dates in the format YYYY-MM-DD")
+	}
+}
+
+// The setMovieReleases() function replaces the per-country release dates for a movie.
+func setMovieReleases(ctx context.Context, tx DBTX, movieID int64, releases map[string]string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM movie_releases WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	if len(releases) == 0 {
+		return nil
+	}
+
+	countries := []string{}
+	dates := []string{}
+
+	for country, date := range releases {
+		countries = append(countries, country)
+		dates = append(dates, date)
+	}
+
+	query := `
+		INSERT INTO movie_releases (movie_id, country, release_date)
+		SELECT $1, country, release_date::date FROM unnest($2::text[], $3::text[]) AS r(country, release_date)`
+
+	_, err = tx.ExecContext(ctx, query, movieID, pq.Array(countries), pq.Array(dates))
+
+	return err
+}
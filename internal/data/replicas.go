@@ -0,0 +1,157 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The ReplicaSet type holds the connection pools for our read replicas, and keeps track of which of them are healthy. Reads
+// are spread across the healthy replicas in turn. A replica is unhealthy when it can't be reached, or when it's fallen so far
+// behind the primary that clients would notice; it's taken out of rotation until a later check finds it healthy again.
+type ReplicaSet struct {
+	replicas []*replica
+	next     atomic.Uint64
+}
+
+type replica struct {
+	db      *sql.DB
+	healthy atomic.Bool
+}
+
+// The ReplicaStatus struct is the result of checking one replica. Replicas are numbered from 1, in the order they were given to
+// NewReplicaSet(). Changed is set when the replica has just gone into or out of rotation.
+type ReplicaStatus struct {
+	Replica int
+	Healthy bool
+	Changed bool
+	Lag     time.Duration
+	Err     error
+}
+
+// The NewReplicaSet() function returns a ReplicaSet for the given connection pools. Every replica starts in rotation, so
+// Check() should be called before the set is used, to take out any that are unhealthy.
+func NewReplicaSet(dbs []*sql.DB) *ReplicaSet {
+	rs := &ReplicaSet{}
+
+	for _, db := range dbs {
+		r := &replica{db: db}
+		r.healthy.Store(true)
+
+		rs.replicas = append(rs.replicas, r)
+	}
+
+	return rs
+}
+
+// The replicaLagSQL query measures how far a replica is behind the primary. pg_last_xact_replay_timestamp() is the commit time
+// of the last transaction the replica replayed, which on its own would make a replica look further and further behind while
+// the primary is idle, so when the replica has replayed everything it has received we count it as not lagging at all.
+const replicaLagSQL = `
+	SELECT pg_is_in_recovery(),
+		CASE
+			WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+			ELSE COALESCE(EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()), 0)
+		END`
+
+// The Check() method pings every replica and measures its lag, puts the ones which respond and are no more than maxLag behind
+// into rotation and takes the rest out, and returns what it found. The replicas are checked at the same time, so one that's
+// hanging doesn't hold up the others.
+func (rs *ReplicaSet) Check(maxLag time.Duration) []ReplicaStatus {
+	statuses := make([]ReplicaStatus, len(rs.replicas))
+
+	var wg sync.WaitGroup
+
+	for i, r := range rs.replicas {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			status := ReplicaStatus{Replica: i + 1}
+			status.Lag, status.Err = r.lag()
+
+			if status.Err == nil && status.Lag > maxLag {
+				status.Err = fmt.Errorf("replica is %s behind the primary", status.Lag.Round(time.Millisecond))
+			}
+
+			status.Healthy = status.Err == nil
+			status.Changed = r.healthy.Swap(status.Healthy) != status.Healthy
+
+			statuses[i] = status
+		}()
+	}
+
+	wg.Wait()
+
+	return statuses
+}
+
+// The lag() method returns how far the replica is behind the primary. A server that isn't in recovery isn't a replica at all,
+// which almost certainly means a DSN is wrong, so we refuse to use it rather than risk sending reads somewhere unexpected.
+func (r *replica) lag() (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var recovering bool
+	var seconds float64
+
+	err := r.db.QueryRowContext(ctx, replicaLagSQL).Scan(&recovering, &seconds)
+	if err != nil {
+		return 0, err
+	}
+
+	if !recovering {
+		return 0, errors.New("server is not a replica")
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// The pick() method returns the next healthy replica's connection pool, or nil if none of them are healthy. It's safe to call on
+// a nil ReplicaSet, which has no replicas.
+func (rs *ReplicaSet) pick() *sql.DB {
+	if rs == nil || len(rs.replicas) == 0 {
+		return nil
+	}
+
+	start := rs.next.Add(1)
+
+	for i := range rs.replicas {
+		r := rs.replicas[(start+uint64(i))%uint64(len(rs.replicas))]
+
+		if r.healthy.Load() {
+			return r.db
+		}
+	}
+
+	return nil
+}
+
+// The Close() method closes the replicas' connection pools.
+func (rs *ReplicaSet) Close() {
+	for _, r := range rs.replicas {
+		r.db.Close()
+	}
+}
+
+// The Replica() method returns a copy of the model whose GetAll() and Facets() read from one of the healthy read replicas, as
+// does Get() when the movie cache is turned off. Replicas are a little behind the primary, so it should only be used where slightly stale data is fine, and since
+// they're read-only, never for writes. If no replica is healthy, or the model is working inside a transaction, the model
+// is returned as it is.
+func (m MovieModel) Replica() MovieModel {
+	if _, ok := m.DB.(*sql.DB); !ok {
+		return m
+	}
+
+	if db := m.Replicas.pick(); db != nil {
+		m.DB = db
+		m.pool = db
+	}
+
+	return m
+}
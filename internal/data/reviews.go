@@ -0,0 +1,262 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrDuplicateReview error, for when a user tries to review the same movie twice.
+var (
+	ErrDuplicateReview = errors.New("duplicate review")
+)
+
+// A Review is one user's score for a movie, from 1 to 10, with an optional written review.
+type Review struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	MovieID   int64     `json:"movie_id"`
+	UserID    int64     `json:"user_id"`
+	UserName  string    `json:"user_name,omitempty"`
+	Score     int32     `json:"score"`
+	Body      string    `json:"body,omitempty"`
+	Version   int32     `json:"version"`
+}
+
+// Define a ReviewModel struct type which wraps a sql.DB connection pool.
+type ReviewModel struct {
+	DB DBTX
+}
+
+func ValidateReview(v *validator.Validator, review *Review) {
+	v.Check(review.Score != 0, "score", "must be provided")
+	v.Check(review.Score >= 1 && review.Score <= 10, "score", "must be between 1 and 10")
+
+	v.Check(len(review.Body) <= 10_000, "body", "must not be more than 10000 bytes long")
+}
+
+// The Insert() method adds a review, and adds its score to the movie's running rating totals in the same transaction.
+// The totals are only ever changed with relative updates like "rating_count = rating_count + 1", and the UPDATE holds the movie
+// row's lock until we commit, so concurrent reviews of the same movie queue up behind each other rather than overwriting each other's changes.
+// If the movie doesn't exist we return ErrRecordNotFound, and if the user has already reviewed it we return ErrDuplicateReview.
+func (m ReviewModel) Insert(review *Review) error {
+	query := `
+		INSERT INTO reviews (movie_id, user_id, score, body)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at, version`
+
+	args := []interface{}{review.MovieID, review.UserID, review.Score, review.Body}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, query, args...).Scan(&review.ID, &review.CreatedAt, &review.UpdatedAt, &review.Version)
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23503": // foreign_key_violation
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23505": // unique_violation
+			return ErrDuplicateReview
+		default:
+			return err
+		}
+	}
+
+	err = adjustRating(ctx, tx, review.MovieID, 1, review.Score)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The GetForUser() method fetches the review a user has written for a movie.
+func (m ReviewModel) GetForUser(movieID, userID int64) (*Review, error) {
+	query := `
+		SELECT r.id, r.created_at, r.updated_at, r.movie_id, r.user_id, u.name, r.score, r.body, r.version
+		FROM reviews r
+		INNER JOIN users u ON u.id = r.user_id
+		WHERE r.movie_id = $1 AND r.user_id = $2`
+
+	var review Review
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, userID).Scan(
+		&review.ID,
+		&review.CreatedAt,
+		&review.UpdatedAt,
+		&review.MovieID,
+		&review.UserID,
+		&review.UserName,
+		&review.Score,
+		&review.Body,
+		&review.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &review, nil
+}
+
+// The GetAllForMovie() method returns a page of the reviews for a movie.
+func (m ReviewModel) GetAllForMovie(movieID int64, filters Filters) ([]*Review, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), r.id, r.created_at, r.updated_at, r.movie_id, r.user_id, u.name, r.score, r.body, r.version
+		FROM reviews r
+		INNER JOIN users u ON u.id = r.user_id
+		WHERE r.movie_id = $1
+		ORDER BY r.%s %s, r.id ASC
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	reviews := []*Review{}
+
+	for rows.Next() {
+		var review Review
+
+		err := rows.Scan(
+			&totalRecords,
+			&review.ID,
+			&review.CreatedAt,
+			&review.UpdatedAt,
+			&review.MovieID,
+			&review.UserID,
+			&review.UserName,
+			&review.Score,
+			&review.Body,
+			&review.Version,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		reviews = append(reviews, &review)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return reviews, metadata, nil
+}
+
+// The Update() method changes the score and body of a review, using the version number for optimistic locking in the same way
+// as MovieModel.Update(). The old score is read while holding a lock on the review, so the difference we apply to the movie's
+// rating total is always against the score that's actually being replaced.
+func (m ReviewModel) Update(review *Review) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldScore int32
+
+	err = tx.QueryRowContext(ctx, `SELECT score FROM reviews WHERE id = $1 AND version = $2 FOR UPDATE`, review.ID, review.Version).Scan(&oldScore)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	query := `
+		UPDATE reviews
+		SET score = $1, body = $2, updated_at = NOW(), version = version + 1
+		WHERE id = $3
+		RETURNING updated_at, version`
+
+	err = tx.QueryRowContext(ctx, query, review.Score, review.Body, review.ID).Scan(&review.UpdatedAt, &review.Version)
+	if err != nil {
+		return err
+	}
+
+	err = adjustRating(ctx, tx, review.MovieID, 0, review.Score-oldScore)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The Delete() method deletes a user's review of a movie, and takes its score back out of the movie's rating totals.
+func (m ReviewModel) Delete(movieID, userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var score int32
+
+	err = tx.QueryRowContext(ctx, `DELETE FROM reviews WHERE movie_id = $1 AND user_id = $2 RETURNING score`, movieID, userID).Scan(&score)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	err = adjustRating(ctx, tx, movieID, -1, -score)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The adjustRating() function applies a change to a movie's rating count and total. The average_rating column is generated from
+// these by PostgreSQL. Notice that we don't touch the movie's version: a new review isn't an edit, and shouldn't cause
+// edit conflicts for someone updating the movie's details at the same time.
+func adjustRating(ctx context.Context, tx DBTX, movieID int64, count, total int32) error {
+	query := `
+		UPDATE movies
+		SET rating_count = rating_count + $1, rating_total = rating_total + $2
+		WHERE id = $3`
+
+	_, err := tx.ExecContext(ctx, query, count, total, movieID)
+	return err
+}
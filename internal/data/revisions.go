@@ -0,0 +1,231 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The actions that create a new revision of a movie. A baseline revision holds the state of a movie from before we started
+// recording its history.
+const (
+	RevisionBaseline = "baseline"
+	RevisionInsert   = "insert"
+	RevisionUpdate   = "update"
+	RevisionDelete   = "delete"
+	RevisionRestore  = "restore"
+	RevisionRevert   = "revert"
+)
+
+// The MovieRevision struct describes one version of a movie: what was done to it, by whom and when, and which fields changed.
+// Movie holds the movie as it was at that version, and is only included when fetching a single revision.
+type MovieRevision struct {
+	Version      int32     `json:"version"`
+	Action       string    `json:"action"`
+	ActorID      int64     `json:"actor_id,omitempty"`      // The user who made the change (omitted if the user has since been deleted, or the change predates authentication)
+	RevertedFrom int32     `json:"reverted_from,omitempty"` // The version a revert went back to (only set for reverts)
+	CreatedAt    time.Time `json:"created_at"`
+	Changed      []string  `json:"changed"` // The snapshot fields that differ from the previous version
+	Movie        *Movie    `json:"movie,omitempty"`
+}
+
+// The movieSnapshotSQL expression builds the JSON snapshot of a movie that's stored with each revision. It holds everything
+// an update can change, so that reverting to it restores the movie as it was. The fields are in movieSnapshot below.
+const movieSnapshotSQL = `jsonb_build_object(
+	'title', movies.title,
+	'year', movies.year,
+	'runtime', movies.runtime,
+	'genres', to_jsonb(` + movieGenresSQL + `),
+	'external_ids', COALESCE(` + movieExternalIDsSQL + `, '{}'),
+	'titles', COALESCE(` + movieTitlesSQL + `, '{}'),
+	'status', movies.status,
+	'release_date', to_char(movies.release_date, 'YYYY-MM-DD'),
+	'releases', COALESCE((SELECT jsonb_object_agg(country, to_char(release_date, 'YYYY-MM-DD')) FROM movie_releases WHERE movie_id = movies.id), '{}'),
+	'deleted', movies.deleted_at IS NOT NULL)`
+
+// The movieSnapshot struct is what a snapshot decodes into. The runtime is stored as a plain number of minutes.
+type movieSnapshot struct {
+	Title       string            `json:"title"`
+	Year        int32             `json:"year"`
+	Runtime     int32             `json:"runtime"`
+	Genres      []string          `json:"genres"`
+	ExternalIDs map[string]string `json:"external_ids"`
+	Titles      map[string]string `json:"titles"`
+	Status      string            `json:"status"`
+	ReleaseDate string            `json:"release_date"`
+	Releases    map[string]string `json:"releases"`
+	Deleted     bool              `json:"deleted"`
+}
+
+// The movie() method returns the movie the snapshot describes. Empty maps are left nil, like they are when we read a movie
+// from the movies table.
+func (s movieSnapshot) movie(id int64, version int32) *Movie {
+	movie := &Movie{
+		ID:          id,
+		Title:       s.Title,
+		Year:        s.Year,
+		Runtime:     Runtime(s.Runtime),
+		Genres:      s.Genres,
+		Version:     version,
+		Status:      s.Status,
+		ReleaseDate: s.ReleaseDate,
+	}
+
+	if len(s.ExternalIDs) > 0 {
+		movie.ExternalIDs = s.ExternalIDs
+	}
+	if len(s.Titles) > 0 {
+		movie.Titles = s.Titles
+	}
+	if len(s.Releases) > 0 {
+		movie.Releases = s.Releases
+	}
+
+	return movie
+}
+
+// The recordMovieRevision() function adds a revision holding the movie's current state and version to its history. It must be
+// called in the same transaction as the change, after the change has been made. The changed fields are worked out by comparing
+// the new snapshot with the one in the previous revision, so a movie's first revision lists every field.
+// The routes that change movies all require an authenticated user, so there's always an actor; a nil or anonymous one is
+// still recorded as NULL, in case a change is ever made some other way. revertedFrom is only recorded for reverts.
+func recordMovieRevision(ctx context.Context, tx DBTX, movieID int64, action string, actor *User, revertedFrom int32) error {
+	var actorID int64
+	if actor != nil {
+		actorID = actor.ID
+	}
+
+	query := `
+		INSERT INTO movie_revisions (movie_id, version, action, actor_id, reverted_from, snapshot, changed)
+		SELECT movies.id, movies.version, $2, NULLIF($3::bigint, 0), NULLIF($4::integer, 0), s.snapshot,
+			ARRAY(
+				SELECT e.key FROM jsonb_each(s.snapshot) e
+				WHERE e.value IS DISTINCT FROM (
+					SELECT r.snapshot -> e.key FROM movie_revisions r
+					WHERE r.movie_id = movies.id
+					ORDER BY r.version DESC
+					LIMIT 1)
+				ORDER BY e.key)
+		FROM movies, LATERAL (SELECT ` + movieSnapshotSQL + ` AS snapshot) s
+		WHERE movies.id = $1`
+
+	_, err := tx.ExecContext(ctx, query, movieID, action, actorID, revertedFrom)
+	return err
+}
+
+// The History() method returns a page of a movie's revisions, newest first by default, without the snapshots. Deleted movies
+// still have a history, but if there's no movie with the given ID at all we return ErrRecordNotFound.
+func (m MovieModel) History(movieID int64, filters Filters) ([]*MovieRevision, Metadata, error) {
+	if movieID < 1 {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM movies WHERE id = $1)`, movieID).Scan(&exists)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if !exists {
+		return nil, Metadata{}, ErrRecordNotFound
+	}
+
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), version, action, COALESCE(actor_id, 0), COALESCE(reverted_from, 0), created_at, changed
+		FROM movie_revisions
+		WHERE movie_id = $1
+		ORDER BY %s %s
+		LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	rows, err := m.DB.QueryContext(ctx, query, movieID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	revisions := []*MovieRevision{}
+
+	for rows.Next() {
+		var revision MovieRevision
+
+		err := rows.Scan(
+			&totalRecords,
+			&revision.Version,
+			&revision.Action,
+			&revision.ActorID,
+			&revision.RevertedFrom,
+			&revision.CreatedAt,
+			pq.Array(&revision.Changed),
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return revisions, metadata, nil
+}
+
+// The GetRevision() method returns a single revision of a movie, including the movie as it was at that version.
+func (m MovieModel) GetRevision(movieID int64, version int32) (*MovieRevision, error) {
+	if movieID < 1 || version < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+		SELECT version, action, COALESCE(actor_id, 0), COALESCE(reverted_from, 0), created_at, changed, snapshot
+		FROM movie_revisions
+		WHERE movie_id = $1 AND version = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var revision MovieRevision
+	var snapshot []byte
+
+	err := m.DB.QueryRowContext(ctx, query, movieID, version).Scan(
+		&revision.Version,
+		&revision.Action,
+		&revision.ActorID,
+		&revision.RevertedFrom,
+		&revision.CreatedAt,
+		pq.Array(&revision.Changed),
+		&snapshot,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var s movieSnapshot
+
+	err = json.Unmarshal(snapshot, &s)
+	if err != nil {
+		return nil, err
+	}
+
+	revision.Movie = s.movie(movieID, revision.Version)
+
+	return &revision, nil
+}
@@ -0,0 +1,391 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"greenlight/internal/validator"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// The MovieSearch struct holds the optional criteria that GetAll() and Export() use to narrow down the movies they return.
+// The zero value of each field means "don't filter on this".
+type MovieSearch struct {
+	Query         string    // Full-text search over the weighted search column, ranked by relevance
+	Title         string    // Full-text match against the title
+	Genres        []string  // Movies must have all of these genres
+	GenresAny     []string  // Movies must have at least one of these genres
+	GenresExclude []string  // Movies must have none of these genres
+	YearMin       int       // Released in or after this year
+	YearMax       int       // Released in or before this year
+	RuntimeMin    int       // Runtime of at least this many minutes
+	RuntimeMax    int       // Runtime of at most this many minutes
+	CreatedAfter  time.Time // Added to the catalogue after this time
+	CreatedBefore time.Time // Added to the catalogue before this time
+	PersonID      int64     // Movies that this person is credited on
+	PersonRole    string    // Only count PersonID's credits in this role (director, writer or actor)
+	WatchlistUser int64     // Filter on whether movies are on this user's watchlist
+	InWatchlist   bool      // With WatchlistUser, true keeps only the movies on their watchlist, and false keeps only the ones that aren't
+	Statuses      []string  // Movies with any of these statuses
+	Upcoming      bool      // Only movies that haven't been released yet, or have a release date in the future
+	Fuzzy         bool      // Match Query and Title by trigram similarity instead of full-text search
+}
+
+// A MovieSuggestion is a lightweight search result for type-ahead, with the trigram similarity between the movie title and what was typed.
+type MovieSuggestion struct {
+	ID    int64   `json:"id"`
+	Title string  `json:"title"`
+	Year  int32   `json:"year"`
+	Score float32 `json:"score"`
+}
+
+func ValidateMovieSearch(v *validator.Validator, search MovieSearch) {
+	v.Check(len(search.Query) <= 500, "q", "must not be more than 500 bytes long")
+
+	v.Check(len(search.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(len(search.GenresAny) <= 20, "genres_any", "must not contain more than 20 genres")
+	v.Check(len(search.GenresExclude) <= 20, "genres_exclude", "must not contain more than 20 genres")
+
+	// The year and runtime bounds follow the same rules as ValidateMovie(), so a range that no movie could ever fall into is rejected.
+	if search.YearMin != 0 {
+		v.Check(search.YearMin >= 1888, "year_min", "must be greater than 1888")
+	}
+	if search.YearMax != 0 {
+		v.Check(search.YearMax >= 1888, "year_max", "must be greater than 1888")
+	}
+	if search.YearMin != 0 && search.YearMax != 0 {
+		v.Check(search.YearMin <= search.YearMax, "year_min", "must not be greater than year_max")
+	}
+
+	v.Check(search.RuntimeMin >= 0, "runtime_min", "must be a positive integer")
+	v.Check(search.RuntimeMax >= 0, "runtime_max", "must be a positive integer")
+	if search.RuntimeMin != 0 && search.RuntimeMax != 0 {
+		v.Check(search.RuntimeMin <= search.RuntimeMax, "runtime_min", "must not be greater than runtime_max")
+	}
+
+	if !search.CreatedAfter.IsZero() && !search.CreatedBefore.IsZero() {
+		v.Check(search.CreatedAfter.Before(search.CreatedBefore), "created_after", "must be before created_before")
+	}
+
+	for _, status := range search.Statuses {
+		v.Check(validator.In(status, MovieStatuses...), "status", "must only contain announced, in_production or released")
+	}
+
+	v.Check(search.PersonID >= 0, "person", "must be a positive integer")
+	if search.PersonRole != "" {
+		v.Check(search.PersonID != 0, "person_role", "must be used together with person")
+		v.Check(validator.In(search.PersonRole, CreditRoles...), "person_role", "must be one of director, writer or actor")
+	}
+}
+
+// The movieQuery type holds the parts of a movie list query that depend on the search criteria: the WHERE clause, plus the SQL
+// expressions for the relevance rank and highlighted headline of each row. When there's no full-text query, rank and headline
+// are just constants, so the same SELECT list works either way.
+type movieQuery struct {
+	*where
+	rank     string
+	headline string
+}
+
+// The query() method turns the search criteria into a movieQuery, using the given text search configurations for the full-text
+// query and the title filter.
+// Each condition is written so that it can use an index: the q condition matches the GIN index on the search column, the title
+// condition matches the expression in movies_title_idx (as long as titleConfig is 'simple', which the index is built with),
+// and the array operators (@> and &&) are supported by the GIN index on genres.
+//
+// The q condition parses the query with config, so it only finds the movies whose search column was built with config too.
+// The search column of a movie indexed with another configuration holds differently stemmed words (english indexes "running"
+// as "run", simple as "running"), so MovieModel.Reindex() moves every movie onto the current configuration when it changes.
+func (search MovieSearch) query(config, titleConfig string) *movieQuery {
+	q := &movieQuery{
+		where:    &where{},
+		rank:     "0::real",
+		headline: "''",
+	}
+
+	// Deleted movies are never included.
+	q.add("deleted_at IS NULL")
+
+	// In fuzzy mode, the q and title values are matched against the title using the pg_trgm word similarity operator,
+	// which is supported by the trigram index on title, and results are ranked by how similar they are.
+	// Localized titles are searched too, through their own search column and trigram index in the movie_titles table, and
+	// a movie ranks by whichever of its titles matches best.
+	if search.Fuzzy {
+		if search.Title != "" {
+			q.rank = fuzzyRank(q.arg(search.Title))
+			q.add("(%[1]s <%% title OR id IN (SELECT movie_id FROM movie_titles WHERE %[1]s <%% title))", search.Title)
+		}
+		if search.Query != "" {
+			q.rank = fuzzyRank(q.arg(search.Query))
+			q.add("(%[1]s <%% title OR id IN (SELECT movie_id FROM movie_titles WHERE %[1]s <%% title))", search.Query)
+		}
+	} else if search.Query != "" {
+		tsquery := q.tsquery(config, search.Query)
+
+		// The localized titles are indexed with the 'simple' configuration, so they need a query built with it too.
+		titlesQuery := tsquery
+		if config != "simple" {
+			titlesQuery = q.tsquery("simple", search.Query)
+		}
+
+		// The same placeholders can be referred to any number of times, so we reuse the tsquery expression for the
+		// condition, the rank and the headline. ts_headline() is expensive, but PostgreSQL only evaluates it for the rows
+		// that survive the LIMIT.
+		q.add(fmt.Sprintf("(search @@ %s OR id IN (SELECT movie_id FROM movie_titles WHERE search @@ %s))", tsquery, titlesQuery))
+		q.rank = fmt.Sprintf("GREATEST(ts_rank(search, %s), (SELECT max(ts_rank(mt.search, %s)) FROM movie_titles mt WHERE mt.movie_id = movies.id))", tsquery, titlesQuery)
+		q.headline = fmt.Sprintf("ts_headline(%s::regconfig, title, %s, 'StartSel=<mark>, StopSel=</mark>, HighlightAll=true')", q.arg(config), tsquery)
+	}
+
+	// The title configuration is written into the SQL as a literal, rather than passed as an argument, so that PostgreSQL can see
+	// the expression is the same as the index's. The localized titles are always indexed with 'simple', whatever the title filter
+	// uses for the main title.
+	if search.Title != "" && !search.Fuzzy {
+		cfg := pq.QuoteLiteral(titleConfig)
+		q.add("(to_tsvector("+cfg+"::regconfig, title) @@ plainto_tsquery("+cfg+"::regconfig, %[1]s) OR id IN (SELECT movie_id FROM movie_titles WHERE search @@ plainto_tsquery('simple', %[1]s)))", search.Title)
+	}
+	// Genre names are matched case-insensitively, and through their aliases, against the movie_genres table. For the "all of" filter
+	// we count how many of the wanted genres each movie has, and compare that with the number of distinct genres the names
+	// resolve to, since a genre's name and one of its aliases (or two of its aliases) both count as the same genre. The other
+	// two filters just need to know if a movie has any of them. The handlers reject names that aren't a genre or an alias
+	// before they get here (see resolveSearchGenres()), since the "all of" filter would otherwise just ignore them.
+	if len(search.Genres) > 0 {
+		q.add("id IN (SELECT movie_id FROM movie_genres WHERE genre_id IN "+genreIDsSQL+" GROUP BY movie_id HAVING count(*) = (SELECT count(*) FROM "+genreIDsSQL+" g))", pq.Array(lowerAll(search.Genres)))
+	}
+	if len(search.GenresAny) > 0 {
+		q.add("EXISTS (SELECT 1 FROM movie_genres mg WHERE mg.movie_id = movies.id AND mg.genre_id IN "+genreIDsSQL+")", pq.Array(lowerAll(search.GenresAny)))
+	}
+	if len(search.GenresExclude) > 0 {
+		q.add("NOT EXISTS (SELECT 1 FROM movie_genres mg WHERE mg.movie_id = movies.id AND mg.genre_id IN "+genreIDsSQL+")", pq.Array(lowerAll(search.GenresExclude)))
+	}
+	if search.YearMin != 0 {
+		q.add("year >= %s", search.YearMin)
+	}
+	if search.YearMax != 0 {
+		q.add("year <= %s", search.YearMax)
+	}
+	if search.RuntimeMin != 0 {
+		q.add("runtime >= %s", search.RuntimeMin)
+	}
+	if search.RuntimeMax != 0 {
+		q.add("runtime <= %s", search.RuntimeMax)
+	}
+	if !search.CreatedAfter.IsZero() {
+		q.add("created_at > %s", search.CreatedAfter)
+	}
+	if !search.CreatedBefore.IsZero() {
+		q.add("created_at < %s", search.CreatedBefore)
+	}
+	// The person filter uses the movie_credits_person_id_idx index, which covers both the person and their role.
+	if search.PersonID != 0 && search.PersonRole != "" {
+		q.add("id IN (SELECT movie_id FROM movie_credits WHERE person_id = %s AND role = %s)", search.PersonID, search.PersonRole)
+	} else if search.PersonID != 0 {
+		q.add("id IN (SELECT movie_id FROM movie_credits WHERE person_id = %s)", search.PersonID)
+	}
+	if len(search.Statuses) > 0 {
+		q.add("status = ANY(%s)", pq.Array(search.Statuses))
+	}
+	if search.Upcoming {
+		q.add("(status <> 'released' OR release_date > CURRENT_DATE)")
+	}
+	if search.WatchlistUser != 0 && search.InWatchlist {
+		q.add("id IN (SELECT movie_id FROM watchlist_items WHERE user_id = %s)", search.WatchlistUser)
+	} else if search.WatchlistUser != 0 {
+		q.add("id NOT IN (SELECT movie_id FROM watchlist_items WHERE user_id = %s)", search.WatchlistUser)
+	}
+
+	return q
+}
+
+// The fuzzyRank() function returns the rank expression for a fuzzy search: the word similarity between the search text
+// (given as a placeholder) and the movie's title, or the best of its localized titles if that's higher.
+// GREATEST() ignores NULLs, so movies without localized titles are ranked on their title alone.
+func fuzzyRank(text string) string {
+	return fmt.Sprintf("GREATEST(word_similarity(%[1]s, title), (SELECT max(word_similarity(%[1]s, mt.title)) FROM movie_titles mt WHERE mt.movie_id = movies.id))", text)
+}
+
+// The genreIDsSQL subquery finds the ids of the genres whose name or alias matches any of the lowercased names in the first argument.
+const genreIDsSQL = `(SELECT id FROM genres WHERE lower(name) = ANY(%[1]s) UNION SELECT genre_id FROM genre_aliases WHERE alias = ANY(%[1]s))`
+
+// The lowerAll() function returns a copy of a slice of strings with every value lowercased and trimmed of surrounding whitespace.
+func lowerAll(values []string) []string {
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(strings.TrimSpace(value))
+	}
+	return lowered
+}
+
+// The uniqueLower() function returns the distinct values in a slice of strings, ignoring case and surrounding whitespace.
+func uniqueLower(values []string) []string {
+	seen := make(map[string]bool)
+	unique := []string{}
+
+	for _, value := range lowerAll(values) {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+
+	return unique
+}
+
+// The sortExpression() method returns the SQL expression to sort on for a sort column. Relevance isn't a real column,
+// so it maps to the rank expression, rating maps to the average rating with unrated movies counted as 0 (which matches
+// the movies_rating_idx index), release_date puts undated movies last (which matches movies_release_date_idx), and
+// everything else is used as-is.
+func (q *movieQuery) sortExpression(column string) string {
+	switch column {
+	case "relevance":
+		return q.rank
+	case "rating":
+		return "COALESCE(average_rating, 0)"
+	case "release_date":
+		return "COALESCE(release_date, 'infinity'::date)"
+	}
+
+	return column
+}
+
+// The tsquery() method adds the arguments for a full-text query to the where clause and returns the SQL expression that builds the tsquery.
+// We use websearch_to_tsquery(), which understands the syntax people are used to from search engines: "quoted phrases",
+// OR, and -excluded words. It doesn't support prefix matching though, so any words ending in * (like "godf*") are pulled out and
+// turned into prefix terms with to_tsquery(), then ANDed with the rest of the query.
+func (w *where) tsquery(config, q string) string {
+	rest, prefixes := splitPrefixTerms(q)
+
+	cfg := w.arg(config)
+
+	if len(prefixes) == 0 {
+		return fmt.Sprintf("websearch_to_tsquery(%s::regconfig, %s)", cfg, w.arg(rest))
+	}
+
+	prefix := fmt.Sprintf("to_tsquery(%s::regconfig, %s)", cfg, w.arg(strings.Join(prefixes, " & ")))
+
+	if strings.TrimSpace(rest) == "" {
+		return prefix
+	}
+
+	return fmt.Sprintf("(websearch_to_tsquery(%s::regconfig, %s) && %s)", cfg, w.arg(rest), prefix)
+}
+
+// The splitPrefixTerms() function pulls the words ending in * out of a search query, returning the rest of the query and the prefix terms
+// in to_tsquery() syntax ("godf:*"). Words inside quotes and excluded words (starting with -) are left alone. Everything except letters
+// and digits is stripped from the prefix terms, so they can't inject any tsquery operators of their own.
+func splitPrefixTerms(q string) (string, []string) {
+	var rest, prefixes []string
+
+	quoted := false
+
+	for _, word := range strings.Fields(q) {
+		if !quoted && strings.HasSuffix(word, "*") && !strings.HasPrefix(word, "-") && !strings.Contains(word, `"`) {
+			term := strings.Map(func(r rune) rune {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) {
+					return r
+				}
+				return -1
+			}, word)
+
+			if term != "" {
+				prefixes = append(prefixes, term+":*")
+			}
+			continue
+		}
+
+		// An odd number of quote characters means this word opens or closes a phrase.
+		if strings.Count(word, `"`)%2 == 1 {
+			quoted = !quoted
+		}
+
+		rest = append(rest, word)
+	}
+
+	return strings.Join(rest, " "), prefixes
+}
+
+// The number of movies Reindex() moves onto the current text search configuration in each statement.
+const reindexBatchSize = 1000
+
+// The Reindex() method rebuilds the search column of every movie that was indexed with a text search configuration other than
+// SearchConfig, which happens when the configuration is changed, and returns how many movies it re-indexed. The movies are
+// done in batches, each in a statement of its own, so that a big catalogue isn't locked all at once. The version isn't
+// changed, since the movie itself hasn't.
+func (m MovieModel) Reindex() (int64, error) {
+	query := `
+		UPDATE movies SET search_config = $1::regconfig
+		WHERE id IN (SELECT id FROM movies WHERE search_config <> $1::regconfig LIMIT $2)`
+
+	var total int64
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		result, err := m.DB.ExecContext(ctx, query, m.SearchConfig, reindexBatchSize)
+		cancel()
+		if err != nil {
+			return total, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return total, err
+		}
+
+		total += rowsAffected
+
+		if rowsAffected < reindexBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// The Suggest() method returns up to limit movies whose titles are similar to the text typed so far, best match first.
+// We use word_similarity() rather than similarity() so that a partial or misspelt word ("godfa", "Godfater") scores well against
+// a longer title, and the <% operator in the WHERE clause lets PostgreSQL use the trigram index on title to find candidates.
+// Localized titles are suggested too. Each movie appears once, under whichever of its titles is the best match.
+func (m MovieModel) Suggest(text string, limit int) ([]*MovieSuggestion, error) {
+	query := `
+		SELECT id, title, year, score FROM (
+			SELECT DISTINCT ON (movies.id) movies.id, t.title, movies.year, t.score
+			FROM (
+				SELECT id AS movie_id, title, word_similarity($1, title) AS score FROM movies WHERE $1 <% title AND deleted_at IS NULL
+				UNION ALL
+				SELECT movie_id, title, word_similarity($1, title) FROM movie_titles WHERE $1 <% title
+			) t
+			INNER JOIN movies ON movies.id = t.movie_id
+			WHERE movies.deleted_at IS NULL
+			ORDER BY movies.id, t.score DESC
+		) suggestions
+		ORDER BY score DESC, title ASC, id ASC
+		LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, text, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := []*MovieSuggestion{}
+
+	for rows.Next() {
+		var suggestion MovieSuggestion
+
+		err := rows.Scan(&suggestion.ID, &suggestion.Title, &suggestion.Year, &suggestion.Score)
+		if err != nil {
+			return nil, err
+		}
+
+		suggestions = append(suggestions, &suggestion)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return suggestions, nil
+}
@@ -0,0 +1,123 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"greenlight/internal/validator"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// LanguageTagRX matches the BCP 47 language tags we accept for localized titles: a 2 or 3 letter language, optionally followed
+// by a 4 letter script and then a 2 letter or 3 digit region, like "de", "pt-BR", "zh-Hant" or "es-419".
+var LanguageTagRX = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z]{4})?(-([a-zA-Z]{2}|[0-9]{3}))?$`)
+
+// A movie's localized titles are selected with this subquery, which returns them as a JSON object keyed by language tag, or NULL if there aren't any.
+const movieTitlesSQL = `(SELECT jsonb_object_agg(language, title) FROM movie_titles WHERE movie_id = movies.id)`
+
+// The CanonicalLanguageTag() function returns a language tag in its conventional case: lowercase language, titlecase script
+// and uppercase region, as in "zh-Hant-TW". Tags that don't match LanguageTagRX are returned unchanged.
+func CanonicalLanguageTag(tag string) string {
+	if !LanguageTagRX.MatchString(tag) {
+		return tag
+	}
+
+	parts := strings.Split(tag, "-")
+
+	parts[0] = strings.ToLower(parts[0])
+
+	for i := 1; i < len(parts); i++ {
+		if len(parts[i]) == 4 {
+			parts[i] = strings.ToUpper(parts[i][:1]) + strings.ToLower(parts[i][1:])
+		} else {
+			parts[i] = strings.ToUpper(parts[i])
+		}
+	}
+
+	return strings.Join(parts, "-")
+}
+
+func ValidateTitles(v *validator.Validator, titles map[string]string) {
+	v.Check(len(titles) <= 50, "titles", "must not contain more than 50 titles")
+
+	for tag, title := range titles {
+		v.Check(LanguageTagRX.MatchString(tag), "titles", fmt.Sprintf("contains an invalid language tag %q", tag))
+		v.Check(title != "", "titles", "must not contain empty titles")
+		v.Check(len(title) <= 500, "titles", "must not contain titles more than 500 bytes long")
+	}
+}
+
+// The Localize() method swaps the movie's title for the localized title that best matches the languages the client prefers,
+// most preferred first. The original title is kept in OriginalTitle, and Language is set to the tag of the title we picked.
+// If none of the languages match, the movie keeps its original title and OriginalTitle is left empty, so it isn't repeated
+// in the response. It returns the tag of the title picked, or "" if none was.
+//
+// A language matches a title with the same tag first, and otherwise one for the same base language: a client asking
+// for "de-AT" gets the "de" title if there's no "de-AT" one, and a client asking for "pt" gets a "pt-BR" title.
+func (movie *Movie) Localize(languages []string) string {
+	for _, language := range languages {
+		tag := bestLanguageMatch(language, movie.Titles)
+		if tag != "" {
+			movie.OriginalTitle = movie.Title
+			movie.Title = movie.Titles[tag]
+			movie.Language = tag
+			return tag
+		}
+	}
+
+	return ""
+}
+
+// The bestLanguageMatch() function returns the key in titles that best matches a language tag, or "" if none of them do.
+func bestLanguageMatch(language string, titles map[string]string) string {
+	if _, ok := titles[language]; ok {
+		return language
+	}
+
+	base, _, _ := strings.Cut(language, "-")
+
+	if _, ok := titles[base]; ok {
+		return base
+	}
+
+	// Pick the alphabetically first of the more specific tags, so the choice doesn't depend on map order.
+	best := ""
+
+	for tag := range titles {
+		if strings.HasPrefix(tag, base+"-") && (best == "" || tag < best) {
+			best = tag
+		}
+	}
+
+	return best
+}
+
+// The setMovieTitles() function replaces the localized titles for a movie.
+func setMovieTitles(ctx context.Context, tx DBTX, movieID int64, titles map[string]string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM movie_titles WHERE movie_id = $1`, movieID)
+	if err != nil {
+		return err
+	}
+
+	if len(titles) == 0 {
+		return nil
+	}
+
+	languages := []string{}
+	values := []string{}
+
+	for language, title := range titles {
+		languages = append(languages, language)
+		values = append(values, title)
+	}
+
+	query := `
+		INSERT INTO movie_titles (movie_id, language, title)
+		SELECT $1, * FROM unnest($2::text[], $3::text[])`
+
+	_, err = tx.ExecContext(ctx, query, movieID, pq.Array(languages), pq.Array(values))
+
+	return err
+}
@@ -0,0 +1,159 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The DBTX interface holds the query methods that *sql.DB and *sql.Tx have in common. The models run their queries against
+// a DBTX, which is the connection pool normally, and a transaction inside Models.WithTx().
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// The modelTx type is the transaction a model method does its work in. Usually it's a transaction of its own, but when the model
+// is already running inside a transaction, it's a savepoint in that transaction instead. Either way the method commits it when
+// it's done, and rolls it back if anything goes wrong, without affecting the work done before it.
+type modelTx struct {
+	*sql.Tx
+	savepoint bool
+	done      bool
+}
+
+// The beginTx() function starts a modelTx: a new transaction if db is the connection pool, or a savepoint if db is already a
+// transaction. The options only apply to new transactions, since a savepoint can't change them.
+func beginTx(ctx context.Context, db DBTX, opts *sql.TxOptions) (*modelTx, error) {
+	switch db := db.(type) {
+	case *sql.DB:
+		tx, err := db.BeginTx(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		return &modelTx{Tx: tx}, nil
+	case *sql.Tx:
+		_, err := db.ExecContext(ctx, `SAVEPOINT model`)
+		if err != nil {
+			return nil, err
+		}
+
+		return &modelTx{Tx: db, savepoint: true}, nil
+	default:
+		return nil, errors.New("can't begin a transaction on this connection")
+	}
+}
+
+// The Commit() method commits the transaction, or releases the savepoint.
+func (tx *modelTx) Commit() error {
+	if !tx.savepoint {
+		return tx.Tx.Commit()
+	}
+
+	if tx.done {
+		return sql.ErrTxDone
+	}
+
+	tx.done = true
+
+	_, err := tx.Tx.ExecContext(context.Background(), `RELEASE SAVEPOINT model`)
+	return err
+}
+
+// The Rollback() method rolls back the transaction, or everything since the savepoint. Like sql.Tx's Rollback(), it's safe to
+// defer straight after beginning, because it does nothing once the transaction has been committed.
+func (tx *modelTx) Rollback() error {
+	if !tx.savepoint {
+		return tx.Tx.Rollback()
+	}
+
+	if tx.done {
+		return sql.ErrTxDone
+	}
+
+	tx.done = true
+
+	// We use a fresh context, since the savepoint needs to be rolled back even when the failure was the method's context timing out.
+	_, err := tx.Tx.ExecContext(context.Background(), `ROLLBACK TO SAVEPOINT model`)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Tx.ExecContext(context.Background(), `RELEASE SAVEPOINT model`)
+	return err
+}
+
+// How many times WithTx() tries a transaction that keeps failing with a serialization failure or deadlock, and the delays
+// between the attempts. The delay doubles each time, up to the maximum, and a random amount of it is used so that the
+// transactions which got in each other's way don't all retry at the same moment.
+const (
+	txMaxAttempts  = 5
+	txRetryDelay   = 20 * time.Millisecond
+	txMaxRetryWait = 500 * time.Millisecond
+)
+
+// The WithTx() method runs fn with a copy of the models that all work inside one transaction, and commits the transaction if
+// fn returns nil, or rolls it back and returns fn's error otherwise. The model methods fn calls commit and roll back their own
+// work as savepoints, so a method failing doesn't spoil the transaction if fn decides to carry on.
+//
+// If the transaction fails with a serialization failure or a deadlock, it's rolled back and fn is called again with a new
+// one, so fn must not have side effects outside the database that can't safely be repeated. When WithTx() is called on models
+// that are already inside a transaction, fn simply runs in that transaction, and it's up to the outermost WithTx() to retry.
+func (m Models) WithTx(ctx context.Context, fn func(tx Models) error) error {
+	if m.tx != nil {
+		return fn(m)
+	}
+
+	delay := txRetryDelay
+
+	for attempt := 1; ; attempt++ {
+		err := m.runTx(ctx, fn)
+		if err == nil || !retryable(err) || attempt == txMaxAttempts {
+			return err
+		}
+
+		wait := time.Duration(rand.Int63n(int64(delay))) + delay/2
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return err
+		}
+
+		delay = min(delay*2, txMaxRetryWait)
+	}
+}
+
+// The runTx() method makes one attempt at the transaction for WithTx().
+func (m Models) runTx(ctx context.Context, fn func(tx Models) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(m.withDB(tx))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The retryable() function reports whether a transaction failed because of a serialization failure or a deadlock, in which
+// case trying it again should work.
+func retryable(err error) bool {
+	var pqErr *pq.Error
+
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "40001" || pqErr.Code == "40P01" // serialization_failure, deadlock_detected
+	}
+
+	return false
+}
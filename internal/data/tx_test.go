@@ -0,0 +1,102 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestWithTxRetriesDeadlocks makes two transactions deadlock, by having them update the same two rows in opposite orders, and
+// checks that WithTx() retries the one PostgreSQL chooses as the victim. It needs a database to run against, given by the
+// GREENLIGHT_TEST_DB_DSN environment variable, and is skipped without one.
+func TestWithTxRetriesDeadlocks(t *testing.T) {
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE tx_deadlock_test (id integer PRIMARY KEY, n integer NOT NULL);
+		INSERT INTO tx_deadlock_test VALUES (1, 0), (2, 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE tx_deadlock_test`)
+
+	models := NewModels(db)
+
+	var attempts atomic.Int32
+	var locked sync.WaitGroup
+	locked.Add(2)
+
+	update := func(first, second int) error {
+		try := 0
+
+		return models.WithTx(context.Background(), func(tx Models) error {
+			attempts.Add(1)
+			try++
+
+			_, err := tx.Movies.DB.ExecContext(context.Background(), `UPDATE tx_deadlock_test SET n = n + 1 WHERE id = $1`, first)
+			if err != nil {
+				return err
+			}
+
+			// On the first attempt, wait until both transactions hold their first lock, so that they're bound to deadlock.
+			if try == 1 {
+				locked.Done()
+				locked.Wait()
+			}
+
+			_, err = tx.Movies.DB.ExecContext(context.Background(), `UPDATE tx_deadlock_test SET n = n + 1 WHERE id = $1`, second)
+			return err
+		})
+	}
+
+	errs := make([]error, 2)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		errs[0] = update(1, 2)
+	}()
+
+	go func() {
+		defer wg.Done()
+		errs[1] = update(2, 1)
+	}()
+
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("got error %v; want both transactions to succeed", err)
+		}
+	}
+
+	// One transaction is rolled back by the deadlock and runs again, so there should be three attempts between them.
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("got %d attempts; want 3", n)
+	}
+
+	var total int
+
+	err = db.QueryRow(`SELECT sum(n) FROM tx_deadlock_test`).Scan(&total)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 4 {
+		t.Errorf("got total %d; want 4", total)
+	}
+}
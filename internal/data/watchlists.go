@@ -0,0 +1,383 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"greenlight/internal/validator"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Define a custom ErrAlreadyInWatchlist error, for when a user adds a movie that's already on their watchlist.
+var (
+	ErrAlreadyInWatchlist = errors.New("already in watchlist")
+)
+
+// A WatchlistItem is a movie on a user's watchlist. Position is the item's place in the list, starting at 1.
+type WatchlistItem struct {
+	Position int32     `json:"position"`
+	AddedAt  time.Time `json:"added_at"`
+	Movie    *Movie    `json:"movie"`
+}
+
+// A WatchedEntry records one time a user watched a movie, with an optional personal rating from 1 to 10. This rating is
+// just for the user's own log: it isn't a review, and doesn't count towards the movie's average rating.
+type WatchedEntry struct {
+	ID        int64  `json:"id"`
+	WatchedOn string `json:"watched_on"`
+	Rating    int32  `json:"rating,omitempty"`
+	Movie     *Movie `json:"movie"`
+}
+
+// Define a WatchlistModel struct type which wraps a sql.DB connection pool.
+type WatchlistModel struct {
+	DB DBTX
+}
+
+// Define a WatchedModel struct type which wraps a sql.DB connection pool.
+type WatchedModel struct {
+	DB DBTX
+}
+
+func ValidateWatchedEntry(v *validator.Validator, entry *WatchedEntry) {
+	// Dates are compared as "YYYY-MM-DD" strings, which sort in the same order as the dates themselves. We allow for tomorrow,
+	// because it may already be tomorrow in the user's time zone.
+	watchedOn, err := time.Parse(time.DateOnly, entry.WatchedOn)
+	v.Check(err == nil, "watched_on", "must be a date in the format YYYY-MM-DD")
+	if err == nil {
+		v.Check(watchedOn.Year() >= 1888, "watched_on", "must be after 1888")
+		v.Check(entry.WatchedOn <= time.Now().AddDate(0, 0, 1).Format(time.DateOnly), "watched_on", "must not be in the future")
+	}
+
+	if entry.Rating != 0 {
+		v.Check(entry.Rating >= 1 && entry.Rating <= 10, "rating", "must be between 1 and 10")
+	}
+}
+
+// A list item only includes the movie's own details, not the search-related fields.
+const listMovieSQL = `movies.id, movies.title, movies.year, movies.runtime, ` + movieGenresSQL + `, movies.version, ` + movieRatingSQL + `, ` + moviePosterSQL + `, ` + movieExternalIDsSQL + `, ` + movieTitlesSQL + `, ` + movieReleaseSQL
+
+// The scanListMovie() function returns the Movie and scan destinations for the columns in listMovieSQL.
+func scanListMovie() (*Movie, []interface{}) {
+	var movie Movie
+
+	return &movie, []interface{}{
+		&movie.ID,
+		&movie.Title,
+		&movie.Year,
+		&movie.Runtime,
+		pq.Array(&movie.Genres),
+		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount,
+		posterColumn{&movie.Poster},
+		stringMapColumn{&movie.ExternalIDs},
+		stringMapColumn{&movie.Titles},
+		&movie.Status,
+		&movie.ReleaseDate,
+		stringMapColumn{&movie.Releases},
+	}
+}
+
+// The GetAll() method returns a page of a user's watchlist.
+func (m WatchlistModel) GetAll(userID int64, filters Filters) ([]*WatchlistItem, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), w.position, w.added_at, %s
+		FROM watchlist_items w
+		INNER JOIN movies ON movies.id = w.movie_id
+		WHERE w.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s, w.position ASC
+		LIMIT $2 OFFSET $3`, listMovieSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	items := []*WatchlistItem{}
+
+	for rows.Next() {
+		var item WatchlistItem
+
+		movie, dest := scanListMovie()
+
+		err := rows.Scan(append([]interface{}{&totalRecords, &item.Position, &item.AddedAt}, dest...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		item.Movie = movie
+		items = append(items, &item)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return items, metadata, nil
+}
+
+// The watchlist table holds each user's movies in their chosen order.
+var watchlist = orderedList{table: "watchlist_items", scope: "user_id", item: "movie_id"}
+
+// The Add() method puts a movie on a user's watchlist at the given position, moving the movies at and after that position down
+// one place. A position of 0, or one past the end of the list, adds it to the end.
+// If the movie doesn't exist we return ErrRecordNotFound, and if it's already on the watchlist we return ErrAlreadyInWatchlist.
+func (m WatchlistModel) Add(userID, movieID int64, position int32) (*WatchlistItem, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The foreign key catches movies that don't exist at all, but deleted movies are still in the table, so we check for those here.
+	err = checkMovieNotDeleted(ctx, tx, movieID)
+	if err != nil {
+		return nil, err
+	}
+
+	position, err = watchlist.makeRoom(ctx, tx, userID, position)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		INSERT INTO watchlist_items (user_id, movie_id, position)
+		VALUES ($1, $2, $3)
+		RETURNING added_at`
+
+	item := &WatchlistItem{Position: position}
+
+	err = tx.QueryRowContext(ctx, query, userID, movieID, position).Scan(&item.AddedAt)
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.As(err, &pqErr) && pqErr.Code == "23503": // foreign_key_violation
+			return nil, ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23505": // unique_violation
+			return nil, ErrAlreadyInWatchlist
+		default:
+			return nil, err
+		}
+	}
+
+	return item, tx.Commit()
+}
+
+// The Move() method moves a movie on a user's watchlist to a new position, shifting the movies in between up or down one place.
+// Positions past the end of the list move the movie to the end. It returns the movie's final position.
+func (m WatchlistModel) Move(userID, movieID int64, position int32) (int32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	position, err = watchlist.move(ctx, tx, userID, movieID, position)
+	if err != nil {
+		return 0, err
+	}
+
+	return position, tx.Commit()
+}
+
+// The Remove() method takes a movie off a user's watchlist, and closes the gap it leaves behind.
+func (m WatchlistModel) Remove(userID, movieID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := beginTx(ctx, m.DB, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockWatchlist(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	err = watchlist.remove(ctx, tx, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// The lockWatchlist() function locks a user's watchlist for the rest of the transaction. Rather than locking every item (which
+// wouldn't stop new items being added), we lock the user's row. FOR NO KEY UPDATE doesn't block other tables' foreign keys to
+// the user, so it only queues up other changes to the same watchlist.
+func lockWatchlist(ctx context.Context, tx DBTX, userID int64) error {
+	_, err := tx.ExecContext(ctx, `SELECT id FROM users WHERE id = $1 FOR NO KEY UPDATE`, userID)
+	return err
+}
+
+// The GetAll() method returns a page of a user's watched log.
+func (m WatchedModel) GetAll(userID int64, filters Filters) ([]*WatchedEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+		SELECT count(*) OVER(), w.id, to_char(w.watched_on, 'YYYY-MM-DD'), COALESCE(w.rating, 0), %s
+		FROM watched_log w
+		INNER JOIN movies ON movies.id = w.movie_id
+		WHERE w.user_id = $1 AND movies.deleted_at IS NULL
+		ORDER BY %s %s NULLS LAST, w.id DESC
+		LIMIT $2 OFFSET $3`, listMovieSQL, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*WatchedEntry{}
+
+	for rows.Next() {
+		var entry WatchedEntry
+
+		movie, dest := scanListMovie()
+
+		err := rows.Scan(append([]interface{}{&totalRecords, &entry.ID, &entry.WatchedOn, &entry.Rating}, dest...)...)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entry.Movie = movie
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+
+	return entries, metadata, nil
+}
+
+// The Get() method fetches one of a user's watched log entries. Entries belonging to other users are reported as not found.
+func (m WatchedModel) Get(userID, id int64) (*WatchedEntry, error) {
+	query := `
+		SELECT w.id, to_char(w.watched_on, 'YYYY-MM-DD'), COALESCE(w.rating, 0), ` + listMovieSQL + `
+		FROM watched_log w
+		INNER JOIN movies ON movies.id = w.movie_id
+		WHERE w.id = $1 AND w.user_id = $2 AND movies.deleted_at IS NULL`
+
+	var entry WatchedEntry
+
+	movie, dest := scanListMovie()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(append([]interface{}{&entry.ID, &entry.WatchedOn, &entry.Rating}, dest...)...)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	entry.Movie = movie
+
+	return &entry, nil
+}
+
+// The Insert() method adds an entry to a user's watched log. A movie can be logged any number of times, once for each viewing.
+// The entry is only inserted if the movie exists and hasn't been deleted, and we return ErrRecordNotFound otherwise.
+func (m WatchedModel) Insert(userID int64, entry *WatchedEntry) error {
+	query := `
+		INSERT INTO watched_log (user_id, movie_id, watched_on, rating)
+		SELECT $1::bigint, id, $3::date, $4::integer FROM movies WHERE id = $2 AND deleted_at IS NULL
+		RETURNING id`
+
+	args := []interface{}{userID, entry.Movie.ID, entry.WatchedOn, nullInt32(entry.Rating)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&entry.ID)
+	if err != nil {
+		var pqErr *pq.Error
+
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		case errors.As(err, &pqErr) && pqErr.Code == "23503": // foreign_key_violation (the movie was purged at the same moment)
+			return ErrRecordNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The Update() method changes the date and rating of a watched log entry.
+func (m WatchedModel) Update(userID int64, entry *WatchedEntry) error {
+	query := `
+		UPDATE watched_log
+		SET watched_on = $1, rating = $2
+		WHERE id = $3 AND user_id = $4`
+
+	return m.exec(query, entry.WatchedOn, nullInt32(entry.Rating), entry.ID, userID)
+}
+
+// The Delete() method removes an entry from a user's watched log.
+func (m WatchedModel) Delete(userID, id int64) error {
+	return m.exec(`DELETE FROM watched_log WHERE id = $1 AND user_id = $2`, id, userID)
+}
+
+// The exec() method runs a statement that should affect exactly one of the user's entries, and returns ErrRecordNotFound if it didn't.
+func (m WatchedModel) exec(query string, args ...interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
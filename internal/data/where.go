@@ -0,0 +1,41 @@
+package data
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The where type builds up the WHERE clause for a query from a variable number of conditions.
+// User-provided values never go into the SQL text itself: each one is appended to the args slice and referred to
+// by its $N placeholder, so only the column names and operators that we write ourselves end up in the query.
+type where struct {
+	conditions []string
+	args       []interface{}
+}
+
+// The arg() method adds a value to the argument list and returns the placeholder that refers to it.
+func (w *where) arg(value interface{}) string {
+	w.args = append(w.args, value)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// The add() method adds a condition to the clause. The condition is a format string with a %s verb for each value,
+// which is replaced with that value's placeholder. Use explicit argument indexes (%[1]s) to refer to a value more than once.
+func (w *where) add(condition string, values ...interface{}) {
+	placeholders := make([]interface{}, len(values))
+	for i, value := range values {
+		placeholders[i] = w.arg(value)
+	}
+
+	w.conditions = append(w.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+// The String() method returns the complete WHERE clause, or an empty string if there are no conditions.
+func (w *where) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+
+	return "WHERE " + strings.Join(w.conditions, " AND ")
+}
@@ -0,0 +1,231 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidOperation is returned when an operation in a JSON Patch can't be applied, because it's malformed or its path
+// doesn't exist in the document. ErrTestFailed is returned when a test operation finds a different value to the one it expects.
+var (
+	ErrInvalidOperation = errors.New("invalid patch operation")
+	ErrTestFailed       = errors.New("test operation failed")
+)
+
+// The Operation struct is a single operation in an RFC 6902 JSON Patch document. Value is kept as raw JSON, so that we can tell
+// a null value apart from a missing one.
+type Operation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// The Apply() function applies a JSON Patch to a document, which should be decoded JSON (as produced by json.Unmarshal() into
+// an interface{}), and returns the patched document. The add, remove, replace and test operations are supported. The operations
+// are applied in order, and if any of them fails the whole patch fails, so a test operation can guard the ones after it.
+func Apply(doc interface{}, ops []Operation) (interface{}, error) {
+	for i, op := range ops {
+		var err error
+
+		doc, err = apply(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return doc, nil
+}
+
+func apply(doc interface{}, op Operation) (interface{}, error) {
+	tokens, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("%w: %s needs a value", ErrInvalidOperation, op.Op)
+		}
+
+		err := json.Unmarshal(op.Value, &value)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidOperation, err)
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("%w: unsupported op %q", ErrInvalidOperation, op.Op)
+	}
+
+	return applyAt(doc, tokens, op.Op, value, op.Path)
+}
+
+// The applyAt() function carries out an operation on the node at the end of the path given by tokens, and returns the node
+// it was given with the change made. Arrays can change length, so each level of the document is replaced on the way back up.
+func applyAt(node interface{}, tokens []string, op string, value interface{}, path string) (interface{}, error) {
+	if len(tokens) == 0 {
+		switch op {
+		case "add", "replace":
+			return value, nil
+		case "test":
+			return node, test(node, value, path)
+		default:
+			return nil, fmt.Errorf("%w: can't remove the whole document", ErrInvalidOperation)
+		}
+	}
+
+	token, rest := tokens[0], tokens[1:]
+
+	switch node := node.(type) {
+	case map[string]interface{}:
+		child, ok := node[token]
+
+		if len(rest) > 0 {
+			if !ok {
+				return nil, fmt.Errorf("%w: path %q doesn't exist", ErrInvalidOperation, path)
+			}
+
+			child, err := applyAt(child, rest, op, value, path)
+			if err != nil {
+				return nil, err
+			}
+
+			node[token] = child
+			return node, nil
+		}
+
+		switch op {
+		case "add":
+			node[token] = value
+		case "remove", "replace", "test":
+			if !ok {
+				return nil, fmt.Errorf("%w: path %q doesn't exist", ErrInvalidOperation, path)
+			}
+
+			switch op {
+			case "remove":
+				delete(node, token)
+			case "replace":
+				node[token] = value
+			default:
+				return node, test(child, value, path)
+			}
+		}
+
+		return node, nil
+
+	case []interface{}:
+		// "-" refers to the position after the last element, which only makes sense when adding to the end of the array.
+		if token == "-" && op == "add" && len(rest) == 0 {
+			return append(node, value), nil
+		}
+
+		index, err := parseIndex(token)
+		if err != nil || index > len(node) || (index == len(node) && (op != "add" || len(rest) > 0)) {
+			return nil, fmt.Errorf("%w: path %q doesn't exist", ErrInvalidOperation, path)
+		}
+
+		if len(rest) > 0 {
+			child, err := applyAt(node[index], rest, op, value, path)
+			if err != nil {
+				return nil, err
+			}
+
+			node[index] = child
+			return node, nil
+		}
+
+		switch op {
+		case "add":
+			node = append(node, nil)
+			copy(node[index+1:], node[index:])
+			node[index] = value
+		case "remove":
+			node = append(node[:index], node[index+1:]...)
+		case "replace":
+			node[index] = value
+		default:
+			return node, test(node[index], value, path)
+		}
+
+		return node, nil
+
+	default:
+		return nil, fmt.Errorf("%w: path %q doesn't exist", ErrInvalidOperation, path)
+	}
+}
+
+// The test() function compares a value in the document with the one a test operation expects. JSON doesn't distinguish
+// between kinds of number, but Go does, so if the document wasn't decoded from JSON (and holds an int where the expected
+// value is a float64, say) we put it through JSON first, so that numbers which are equal compare as equal.
+func test(actual, expected interface{}, path string) error {
+	if js, err := json.Marshal(actual); err == nil {
+		json.Unmarshal(js, &actual)
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		return fmt.Errorf("%w: %q doesn't have the expected value", ErrTestFailed, path)
+	}
+
+	return nil
+}
+
+// The parsePointer() function splits an RFC 6901 JSON Pointer, like "/titles/pt-BR", into its unescaped reference tokens.
+// The empty pointer refers to the whole document.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("%w: path %q must start with a slash", ErrInvalidOperation, pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+
+	return tokens, nil
+}
+
+// Array indexes in a JSON Pointer are decimal numbers without leading zeros.
+func parseIndex(token string) (int, error) {
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.ContainsAny(token, "+-") {
+		return 0, ErrInvalidOperation
+	}
+
+	return strconv.Atoi(token)
+}
+
+// The MergePatch() function applies an RFC 7396 JSON Merge Patch to a decoded JSON document, and returns the patched document.
+// Members of the patch replace the matching members of the document, objects are merged recursively, and a null member
+// removes the matching member from the document.
+func MergePatch(doc, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	docObject, ok := doc.(map[string]interface{})
+	if !ok {
+		docObject = map[string]interface{}{}
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(docObject, key)
+			continue
+		}
+
+		docObject[key] = MergePatch(docObject[key], value)
+	}
+
+	return docObject
+}
@@ -0,0 +1,249 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// The decode() function decodes a JSON literal in a test case, failing the test if it's invalid.
+func decode(t *testing.T, js string) interface{} {
+	t.Helper()
+
+	var v interface{}
+
+	err := json.Unmarshal([]byte(js), &v)
+	if err != nil {
+		t.Fatalf("invalid JSON %s: %v", js, err)
+	}
+
+	return v
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		ops  string
+		want string // The patched document, or empty if the patch should fail
+		err  error
+	}{
+		{
+			name: "add member",
+			doc:  `{"title": "Moana"}`,
+			ops:  `[{"op": "add", "path": "/year", "value": 2016}]`,
+			want: `{"title": "Moana", "year": 2016}`,
+		},
+		{
+			name: "replace member",
+			doc:  `{"title": "Moana"}`,
+			ops:  `[{"op": "replace", "path": "/title", "value": "Vaiana"}]`,
+			want: `{"title": "Vaiana"}`,
+		},
+		{
+			name: "remove member",
+			doc:  `{"title": "Moana", "year": 2016}`,
+			ops:  `[{"op": "remove", "path": "/year"}]`,
+			want: `{"title": "Moana"}`,
+		},
+		{
+			name: "add null member",
+			doc:  `{}`,
+			ops:  `[{"op": "add", "path": "/runtime", "value": null}]`,
+			want: `{"runtime": null}`,
+		},
+		{
+			name: "escaped slash",
+			doc:  `{"titles": {}}`,
+			ops:  `[{"op": "add", "path": "/titles/a~1b", "value": "x"}]`,
+			want: `{"titles": {"a/b": "x"}}`,
+		},
+		{
+			name: "escaped tilde",
+			doc:  `{"titles": {}}`,
+			ops:  `[{"op": "add", "path": "/titles/a~0b", "value": "x"}]`,
+			want: `{"titles": {"a~b": "x"}}`,
+		},
+		{
+			name: "escapes are decoded in order",
+			doc:  `{"titles": {}}`,
+			ops:  `[{"op": "add", "path": "/titles/~01", "value": "x"}]`,
+			want: `{"titles": {"~1": "x"}}`,
+		},
+		{
+			name: "append with dash",
+			doc:  `{"genres": ["drama"]}`,
+			ops:  `[{"op": "add", "path": "/genres/-", "value": "comedy"}]`,
+			want: `{"genres": ["drama", "comedy"]}`,
+		},
+		{
+			name: "add at length",
+			doc:  `{"genres": ["drama"]}`,
+			ops:  `[{"op": "add", "path": "/genres/1", "value": "comedy"}]`,
+			want: `{"genres": ["drama", "comedy"]}`,
+		},
+		{
+			name: "insert before index",
+			doc:  `{"genres": ["drama", "comedy"]}`,
+			ops:  `[{"op": "add", "path": "/genres/0", "value": "action"}]`,
+			want: `{"genres": ["action", "drama", "comedy"]}`,
+		},
+		{
+			name: "remove from array",
+			doc:  `{"genres": ["action", "drama", "comedy"]}`,
+			ops:  `[{"op": "remove", "path": "/genres/1"}]`,
+			want: `{"genres": ["action", "comedy"]}`,
+		},
+		{
+			name: "replace whole document",
+			doc:  `{"title": "Moana"}`,
+			ops:  `[{"op": "replace", "path": "", "value": {"title": "Vaiana"}}]`,
+			want: `{"title": "Vaiana"}`,
+		},
+		{
+			name: "test number",
+			doc:  `{"version": 3}`,
+			ops:  `[{"op": "test", "path": "/version", "value": 3.0}, {"op": "replace", "path": "/version", "value": 4}]`,
+			want: `{"version": 4}`,
+		},
+		{
+			name: "test guards later operations",
+			doc:  `{"version": 3, "title": "Moana"}`,
+			ops:  `[{"op": "test", "path": "/version", "value": 2}, {"op": "replace", "path": "/title", "value": "Vaiana"}]`,
+			err:  ErrTestFailed,
+		},
+		{
+			name: "test whole document",
+			doc:  `{"genres": ["drama"]}`,
+			ops:  `[{"op": "test", "path": "", "value": {"genres": ["drama"]}}]`,
+			want: `{"genres": ["drama"]}`,
+		},
+		{
+			name: "remove whole document",
+			doc:  `{"title": "Moana"}`,
+			ops:  `[{"op": "remove", "path": ""}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "dash only for add",
+			doc:  `{"genres": ["drama"]}`,
+			ops:  `[{"op": "remove", "path": "/genres/-"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "add past length",
+			doc:  `{"genres": ["drama"]}`,
+			ops:  `[{"op": "add", "path": "/genres/2", "value": "comedy"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "replace at length",
+			doc:  `{"genres": ["drama"]}`,
+			ops:  `[{"op": "replace", "path": "/genres/1", "value": "comedy"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "leading zero index",
+			doc:  `{"genres": ["drama", "comedy"]}`,
+			ops:  `[{"op": "remove", "path": "/genres/01"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "remove missing member",
+			doc:  `{"title": "Moana"}`,
+			ops:  `[{"op": "remove", "path": "/year"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "missing parent",
+			doc:  `{}`,
+			ops:  `[{"op": "add", "path": "/titles/fr", "value": "Vaiana"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "missing value",
+			doc:  `{}`,
+			ops:  `[{"op": "add", "path": "/title"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "relative path",
+			doc:  `{}`,
+			ops:  `[{"op": "add", "path": "title", "value": "Moana"}]`,
+			err:  ErrInvalidOperation,
+		},
+		{
+			name: "unsupported op",
+			doc:  `{"title": "Moana"}`,
+			ops:  `[{"op": "copy", "from": "/title", "path": "/original_title"}]`,
+			err:  ErrInvalidOperation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ops []Operation
+
+			err := json.Unmarshal([]byte(tt.ops), &ops)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Apply(decode(t, tt.doc), ops)
+
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v; want %v", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
+
+// A test operation compares numbers by value, even when the document holds Go integers rather than decoded JSON numbers.
+func TestApplyTestIntegers(t *testing.T) {
+	doc := map[string]interface{}{"version": int32(3)}
+
+	_, err := Apply(doc, []Operation{{Op: "test", Path: "/version", Value: json.RawMessage(`3`)}})
+	if err != nil {
+		t.Errorf("got error %v; want nil", err)
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		doc   string
+		patch string
+		want  string
+	}{
+		{"replace member", `{"title": "Moana", "year": 2016}`, `{"title": "Vaiana"}`, `{"title": "Vaiana", "year": 2016}`},
+		{"null removes member", `{"title": "Moana", "year": 2016}`, `{"year": null}`, `{"title": "Moana"}`},
+		{"null for missing member", `{"title": "Moana"}`, `{"year": null}`, `{"title": "Moana"}`},
+		{"nested merge", `{"titles": {"fr": "Vaiana", "de": "Vaiana"}}`, `{"titles": {"de": null, "it": "Oceania"}}`, `{"titles": {"fr": "Vaiana", "it": "Oceania"}}`},
+		{"arrays are replaced", `{"genres": ["drama", "comedy"]}`, `{"genres": ["action"]}`, `{"genres": ["action"]}`},
+		{"object replaces scalar", `{"titles": "none"}`, `{"titles": {"fr": "Vaiana"}}`, `{"titles": {"fr": "Vaiana"}}`},
+		{"nested nulls are dropped", `{}`, `{"titles": {"fr": null}}`, `{"titles": {}}`},
+		{"non-object patch replaces document", `{"title": "Moana"}`, `["x"]`, `["x"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := MergePatch(decode(t, tt.doc), decode(t, tt.patch))
+
+			if want := decode(t, tt.want); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v; want %v", got, want)
+			}
+		})
+	}
+}
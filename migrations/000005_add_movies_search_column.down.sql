@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS movies_search_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS search;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS search_config;
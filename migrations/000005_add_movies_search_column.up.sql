@@ -0,0 +1,8 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search_config regconfig NOT NULL DEFAULT 'simple';
+
+-- The title gets weight A. When we add a description column it should be appended here with weight B, like so:
+-- || setweight(to_tsvector(search_config, coalesce(description, '')), 'B')
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (setweight(to_tsvector(search_config, title), 'A')) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_search_idx ON movies USING GIN (search);
@@ -0,0 +1,18 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS genres text[];
+
+UPDATE movies SET genres = ARRAY(
+    SELECT g.name FROM movie_genres mg INNER JOIN genres g ON g.id = mg.genre_id
+    WHERE mg.movie_id = movies.id ORDER BY mg.position
+);
+
+ALTER TABLE movies ALTER COLUMN genres SET NOT NULL;
+
+ALTER TABLE movies ADD CONSTRAINT genres_length_check CHECK (array_length(genres, 1) BETWEEN 1 AND 5);
+
+CREATE INDEX IF NOT EXISTS mvoies_genres_idx ON movies USING GIN (genres);
+
+DROP TABLE IF EXISTS movie_genres;
+
+DROP TABLE IF EXISTS genre_aliases;
+
+DROP TABLE IF EXISTS genres;
@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS genres (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS genres_name_idx ON genres (lower(name));
+
+CREATE TABLE IF NOT EXISTS genre_aliases (
+    alias text PRIMARY KEY,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS genre_aliases_genre_id_idx ON genre_aliases (genre_id);
+
+CREATE TABLE IF NOT EXISTS movie_genres (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    genre_id bigint NOT NULL REFERENCES genres ON DELETE RESTRICT,
+    position integer NOT NULL,
+    PRIMARY KEY (movie_id, genre_id)
+);
+
+CREATE INDEX IF NOT EXISTS movie_genres_genre_id_idx ON movie_genres (genre_id, movie_id);
+
+-- Back-fill the genres from the existing arrays. Names are trimmed, lowercased and have their whitespace collapsed,
+-- so "Comedy" and "comedy " end up as the same genre. Misspellings like "comdey" still get a genre of their own, and
+-- can be merged into the right one afterwards with DELETE /v1/genres/:id?merge_into=...
+INSERT INTO genres (name)
+SELECT DISTINCT lower(regexp_replace(trim(g), '\s+', ' ', 'g'))
+FROM movies CROSS JOIN LATERAL unnest(genres) AS g
+WHERE trim(g) <> ''
+ON CONFLICT DO NOTHING;
+
+-- Keep the original order of each movie's genres. If a movie had two spellings of the same genre, only the first one counts.
+INSERT INTO movie_genres (movie_id, genre_id, position)
+SELECT m.id, g.id, min(n.position)
+FROM movies m
+CROSS JOIN LATERAL unnest(m.genres) WITH ORDINALITY AS n(name, position)
+INNER JOIN genres g ON g.name = lower(regexp_replace(trim(n.name), '\s+', ' ', 'g'))
+GROUP BY m.id, g.id;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS genres_length_check;
+
+DROP INDEX IF EXISTS mvoies_genres_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS genres;
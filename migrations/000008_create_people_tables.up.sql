@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS people (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    name text NOT NULL,
+    birth_year integer,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people (lower(name));
+
+CREATE TABLE IF NOT EXISTS movie_credits (
+    id bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    person_id bigint NOT NULL REFERENCES people ON DELETE RESTRICT,
+    role text NOT NULL,
+    character text,
+    billing_order integer,
+    CONSTRAINT movie_credits_role_check CHECK (role IN ('director', 'writer', 'actor')),
+    CONSTRAINT movie_credits_actor_check CHECK (role = 'actor' OR (character IS NULL AND billing_order IS NULL)),
+    CONSTRAINT movie_credits_billing_order_check CHECK (billing_order > 0)
+);
+
+-- A person can only have each role once on a movie, except that an actor can play more than one character.
+CREATE UNIQUE INDEX IF NOT EXISTS movie_credits_unique_idx ON movie_credits (movie_id, person_id, role, COALESCE(character, ''));
+
+CREATE INDEX IF NOT EXISTS movie_credits_person_id_idx ON movie_credits (person_id, role, movie_id);
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS reviews (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    score integer NOT NULL,
+    body text NOT NULL DEFAULT '',
+    version integer NOT NULL DEFAULT 1,
+    CONSTRAINT reviews_score_check CHECK (score BETWEEN 1 AND 10),
+    CONSTRAINT reviews_movie_id_user_id_key UNIQUE (movie_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS reviews_user_id_idx ON reviews (user_id);
+
+-- The running totals are adjusted in the same transaction as every review write, and the average is derived from them,
+-- so it can never drift from the totals. Movies with no reviews have a NULL average.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_total integer NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS average_rating numeric(4, 2) GENERATED ALWAYS AS (
+    CASE WHEN rating_count > 0 THEN round(rating_total::numeric / rating_count, 2) END
+) STORED;
+
+CREATE INDEX IF NOT EXISTS movies_rating_idx ON movies ((COALESCE(average_rating, 0)), id);
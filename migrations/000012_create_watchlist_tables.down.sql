@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS watched_log;
+
+DROP TABLE IF EXISTS watchlist_items;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS watchlist_items (
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, movie_id),
+    -- Reordering shifts several items at once, so the uniqueness of positions is only checked when the transaction commits.
+    CONSTRAINT watchlist_items_position_key UNIQUE (user_id, position) DEFERRABLE INITIALLY DEFERRED,
+    CONSTRAINT watchlist_items_position_check CHECK (position > 0)
+);
+
+-- Used by the in_watchlist filter on the movie list, and when a movie is deleted.
+CREATE INDEX IF NOT EXISTS watchlist_items_movie_id_idx ON watchlist_items (movie_id);
+
+CREATE TABLE IF NOT EXISTS watched_log (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    watched_on date NOT NULL,
+    rating integer,
+    CONSTRAINT watched_log_rating_check CHECK (rating BETWEEN 1 AND 10)
+);
+
+CREATE INDEX IF NOT EXISTS watched_log_user_id_idx ON watched_log (user_id, watched_on);
+
+CREATE INDEX IF NOT EXISTS watched_log_movie_id_idx ON watched_log (movie_id);
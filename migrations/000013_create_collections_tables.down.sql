@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS collection_removals;
+
+DROP TABLE IF EXISTS collection_movies;
+
+DROP TABLE IF EXISTS collections;
@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS collections (
+    id bigserial PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    owner_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+    name text NOT NULL,
+    description text NOT NULL DEFAULT '',
+    public boolean NOT NULL DEFAULT false,
+    share_token text UNIQUE NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+CREATE INDEX IF NOT EXISTS collections_owner_id_idx ON collections (owner_id);
+
+CREATE INDEX IF NOT EXISTS collections_public_idx ON collections (id) WHERE public;
+
+CREATE TABLE IF NOT EXISTS collection_movies (
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    position integer NOT NULL,
+    added_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (collection_id, movie_id),
+    CONSTRAINT collection_movies_position_key UNIQUE (collection_id, position) DEFERRABLE INITIALLY DEFERRED,
+    CONSTRAINT collection_movies_position_check CHECK (position > 0)
+);
+
+CREATE INDEX IF NOT EXISTS collection_movies_movie_id_idx ON collection_movies (movie_id);
+
+-- Movies deleted while they were in a collection. The movie is gone, so there's no foreign key, and we keep a copy of its title.
+CREATE TABLE IF NOT EXISTS collection_removals (
+    id bigserial PRIMARY KEY,
+    collection_id bigint NOT NULL REFERENCES collections ON DELETE CASCADE,
+    movie_id bigint NOT NULL,
+    movie_title text NOT NULL,
+    position integer NOT NULL,
+    removed_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS collection_removals_collection_id_idx ON collection_removals (collection_id);
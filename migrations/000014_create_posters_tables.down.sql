@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS orphaned_blobs;
+
+DROP TABLE IF EXISTS movie_posters;
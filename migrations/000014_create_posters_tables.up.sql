@@ -0,0 +1,16 @@
+-- Each movie has at most one poster. The poster column holds what clients see (the image size and the URLs of each version),
+-- and blob_keys the keys of every blob stored for it, so they can be cleaned up when the poster is replaced or the movie deleted.
+CREATE TABLE IF NOT EXISTS movie_posters (
+    movie_id bigint PRIMARY KEY REFERENCES movies ON DELETE CASCADE,
+    poster jsonb NOT NULL,
+    blob_keys text[] NOT NULL,
+    uploaded_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+-- Blobs that nothing refers to any more, waiting for the garbage collector to delete them from the blob store.
+CREATE TABLE IF NOT EXISTS orphaned_blobs (
+    key text PRIMARY KEY,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS orphaned_blobs_created_at_idx ON orphaned_blobs (created_at);
@@ -0,0 +1,9 @@
+-- The IDs other databases (like IMDb) use for a movie. A movie has at most one ID from each source, and an ID can only belong to one movie.
+CREATE TABLE IF NOT EXISTS movie_external_ids (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    source text NOT NULL,
+    external_id text NOT NULL,
+    PRIMARY KEY (movie_id, source),
+    CONSTRAINT movie_external_ids_source_external_id_key UNIQUE (source, external_id),
+    CONSTRAINT movie_external_ids_source_check CHECK (source IN ('imdb', 'tmdb', 'wikidata'))
+);
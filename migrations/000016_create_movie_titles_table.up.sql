@@ -0,0 +1,14 @@
+-- Alternate titles for a movie, like its local release titles, keyed by BCP 47 language tag ("de", "pt-BR", "zh-Hant").
+-- Localized titles are searched alongside the original title. They can be in any language, so the search column uses the
+-- 'simple' configuration, which doesn't do any stemming.
+CREATE TABLE IF NOT EXISTS movie_titles (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    language text NOT NULL,
+    title text NOT NULL,
+    search tsvector GENERATED ALWAYS AS (to_tsvector('simple', title)) STORED,
+    PRIMARY KEY (movie_id, language)
+);
+
+CREATE INDEX IF NOT EXISTS movie_titles_search_idx ON movie_titles USING GIN (search);
+
+CREATE INDEX IF NOT EXISTS movie_titles_title_trgm_idx ON movie_titles USING GIN (title gin_trgm_ops);
@@ -0,0 +1,27 @@
+-- Before this migration, no movie could have a year in the future, so the old year check can't be put back while any do.
+-- Rather than decide what to do with those movies here, we stop the rollback and leave it to whoever is running it.
+DO $$
+DECLARE
+    future bigint;
+BEGIN
+    SELECT count(*) INTO future FROM movies WHERE year > date_part('year', now());
+
+    IF future > 0 THEN
+        RAISE EXCEPTION 'can''t roll back: % movies have a year in the future, which the old year check doesn''t allow', future
+            USING HINT = 'Delete those movies, or correct their years, and try again.';
+    END IF;
+END $$;
+
+DROP TABLE IF EXISTS movie_releases;
+
+DROP INDEX IF EXISTS movies_release_date_idx;
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year BETWEEN 1888 AND date_part('year', now()));
+
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_status_check;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS release_date;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS status;
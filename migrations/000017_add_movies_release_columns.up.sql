@@ -0,0 +1,23 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS status text NOT NULL DEFAULT 'released';
+
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS release_date date;
+
+ALTER TABLE movies ADD CONSTRAINT movies_status_check CHECK (status IN ('announced', 'in_production', 'released'));
+
+-- Films that haven't been released yet can have a year in the future, so the year check only applies to released films.
+ALTER TABLE movies DROP CONSTRAINT IF EXISTS movies_year_check;
+
+ALTER TABLE movies ADD CONSTRAINT movies_year_check CHECK (year >= 1888 AND (status <> 'released' OR year <= date_part('year', now())));
+
+-- The upcoming films list is sorted by release date, and only includes films that haven't been released, or will be released
+-- in the future. Undated films sort last.
+CREATE INDEX IF NOT EXISTS movies_release_date_idx ON movies (COALESCE(release_date, 'infinity'::date), id);
+
+-- The dates a movie is released in each country, keyed by ISO 3166-1 alpha-2 country code.
+CREATE TABLE IF NOT EXISTS movie_releases (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    country text NOT NULL,
+    release_date date NOT NULL,
+    PRIMARY KEY (movie_id, country),
+    CONSTRAINT movie_releases_country_check CHECK (country ~ '^[A-Z]{2}$')
+);
@@ -0,0 +1,5 @@
+ALTER TABLE users DROP COLUMN IF EXISTS admin;
+
+DROP INDEX IF EXISTS movies_deleted_at_idx;
+
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
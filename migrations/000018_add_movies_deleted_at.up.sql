@@ -0,0 +1,8 @@
+-- Deleted movies are kept as tombstones, so they can be restored, until they're purged.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+
+CREATE INDEX IF NOT EXISTS movies_deleted_at_idx ON movies (deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- Admins can do things other users can't, like purging deleted movies for good. There's no API for making someone an admin;
+-- it's done directly in the database.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS admin boolean NOT NULL DEFAULT false;
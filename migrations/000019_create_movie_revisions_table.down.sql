@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS movie_revisions;
+
+DROP FUNCTION IF EXISTS movie_revisions_immutable();
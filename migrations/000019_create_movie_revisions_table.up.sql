@@ -0,0 +1,45 @@
+-- Every change to a movie is recorded as a revision: a snapshot of the movie at that version, who made the change, and which
+-- fields it changed. Revisions are deleted along with the movie when it's purged, but are otherwise never changed.
+CREATE TABLE IF NOT EXISTS movie_revisions (
+    movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+    version integer NOT NULL,
+    action text NOT NULL,
+    actor_id bigint REFERENCES users ON DELETE SET NULL,
+    reverted_from integer,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    snapshot jsonb NOT NULL,
+    changed text[] NOT NULL,
+    PRIMARY KEY (movie_id, version),
+    CONSTRAINT movie_revisions_action_check CHECK (action IN ('baseline', 'insert', 'update', 'delete', 'restore', 'revert'))
+);
+
+CREATE OR REPLACE FUNCTION movie_revisions_immutable() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'movie revisions can not be changed';
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movie_revisions_immutable ON movie_revisions;
+
+CREATE TRIGGER movie_revisions_immutable BEFORE UPDATE ON movie_revisions
+    FOR EACH ROW EXECUTE FUNCTION movie_revisions_immutable();
+
+-- History starts now, so each existing movie gets a baseline revision holding its current state. This builds the same snapshot
+-- as movieSnapshotSQL in internal/data/revisions.go.
+INSERT INTO movie_revisions (movie_id, version, action, snapshot, changed)
+SELECT movies.id, movies.version, 'baseline', s.snapshot, ARRAY(SELECT jsonb_object_keys(s.snapshot) ORDER BY 1)
+FROM movies, LATERAL (SELECT jsonb_build_object(
+    'title', movies.title,
+    'year', movies.year,
+    'runtime', movies.runtime,
+    'genres', to_jsonb(ARRAY(
+        SELECT g.name FROM movie_genres mg INNER JOIN genres g ON g.id = mg.genre_id
+        WHERE mg.movie_id = movies.id ORDER BY mg.position)),
+    'external_ids', COALESCE((SELECT jsonb_object_agg(source, external_id) FROM movie_external_ids WHERE movie_id = movies.id), '{}'),
+    'titles', COALESCE((SELECT jsonb_object_agg(language, title) FROM movie_titles WHERE movie_id = movies.id), '{}'),
+    'status', movies.status,
+    'release_date', to_char(movies.release_date, 'YYYY-MM-DD'),
+    'releases', COALESCE((SELECT jsonb_object_agg(country, to_char(release_date, 'YYYY-MM-DD')) FROM movie_releases WHERE movie_id = movies.id), '{}'),
+    'deleted', movies.deleted_at IS NOT NULL
+) AS snapshot) s
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,10 @@
+DROP TRIGGER IF EXISTS movies_movie_cache ON movies;
+DROP TRIGGER IF EXISTS movie_genres_movie_cache ON movie_genres;
+DROP TRIGGER IF EXISTS movie_posters_movie_cache ON movie_posters;
+DROP TRIGGER IF EXISTS movie_external_ids_movie_cache ON movie_external_ids;
+DROP TRIGGER IF EXISTS movie_titles_movie_cache ON movie_titles;
+DROP TRIGGER IF EXISTS movie_releases_movie_cache ON movie_releases;
+DROP TRIGGER IF EXISTS genres_movie_cache ON genres;
+
+DROP FUNCTION IF EXISTS notify_movie_cache();
+DROP FUNCTION IF EXISTS notify_movie_cache_flush();
@@ -0,0 +1,55 @@
+-- Tell the API's movie caches which movies have changed, by sending their IDs on the movie_cache channel. The trigger's
+-- argument names the column holding the movie ID. Notifications are only delivered when the transaction commits, and
+-- PostgreSQL sends a transaction's identical notifications once, so a movie is only invalidated once however much changes.
+CREATE OR REPLACE FUNCTION notify_movie_cache() RETURNS trigger AS $$
+DECLARE
+    changed jsonb;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed := to_jsonb(OLD);
+    ELSE
+        changed := to_jsonb(NEW);
+    END IF;
+
+    PERFORM pg_notify('movie_cache', changed ->> TG_ARGV[0]);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Renaming or deleting a genre changes every movie in it, so rather than listing them we ask the caches to empty completely.
+CREATE OR REPLACE FUNCTION notify_movie_cache_flush() RETURNS trigger AS $$
+BEGIN
+    PERFORM pg_notify('movie_cache', '*');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- New movies aren't in any cache yet, so the movies table only needs to notify on updates and deletes. Reviews change a movie's
+-- average rating, which is stored in the movies table, so they're covered too.
+DROP TRIGGER IF EXISTS movies_movie_cache ON movies;
+CREATE TRIGGER movies_movie_cache AFTER UPDATE OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_cache('id');
+
+DROP TRIGGER IF EXISTS movie_genres_movie_cache ON movie_genres;
+CREATE TRIGGER movie_genres_movie_cache AFTER INSERT OR UPDATE OR DELETE ON movie_genres
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_cache('movie_id');
+
+DROP TRIGGER IF EXISTS movie_posters_movie_cache ON movie_posters;
+CREATE TRIGGER movie_posters_movie_cache AFTER INSERT OR UPDATE OR DELETE ON movie_posters
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_cache('movie_id');
+
+DROP TRIGGER IF EXISTS movie_external_ids_movie_cache ON movie_external_ids;
+CREATE TRIGGER movie_external_ids_movie_cache AFTER INSERT OR UPDATE OR DELETE ON movie_external_ids
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_cache('movie_id');
+
+DROP TRIGGER IF EXISTS movie_titles_movie_cache ON movie_titles;
+CREATE TRIGGER movie_titles_movie_cache AFTER INSERT OR UPDATE OR DELETE ON movie_titles
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_cache('movie_id');
+
+DROP TRIGGER IF EXISTS movie_releases_movie_cache ON movie_releases;
+CREATE TRIGGER movie_releases_movie_cache AFTER INSERT OR UPDATE OR DELETE ON movie_releases
+    FOR EACH ROW EXECUTE FUNCTION notify_movie_cache('movie_id');
+
+DROP TRIGGER IF EXISTS genres_movie_cache ON genres;
+CREATE TRIGGER genres_movie_cache AFTER UPDATE OR DELETE ON genres
+    FOR EACH STATEMENT EXECUTE FUNCTION notify_movie_cache_flush();
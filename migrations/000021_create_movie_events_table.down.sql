@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS movies_record_event ON movies;
+DROP TRIGGER IF EXISTS movies_record_update_event ON movies;
+
+DROP FUNCTION IF EXISTS record_movie_event();
+
+DROP TABLE IF EXISTS movie_events;
@@ -0,0 +1,54 @@
+-- The change feed: an event for every movie that's added, changed or deleted, numbered in the order they happened. Listeners
+-- are told about each event as it's committed, and read the table to catch up on the ones they missed while disconnected.
+-- Events outlive the movies they're about, so there's no foreign key.
+CREATE TABLE IF NOT EXISTS movie_events (
+    seq bigserial PRIMARY KEY,
+    movie_id bigint NOT NULL,
+    version integer NOT NULL,
+    operation text NOT NULL,
+    created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+    CONSTRAINT movie_events_operation_check CHECK (operation IN ('insert', 'update', 'delete'))
+);
+
+CREATE INDEX IF NOT EXISTS movie_events_created_at_idx ON movie_events (created_at);
+
+-- Moving a movie into the trash is a delete as far as the feed is concerned, and restoring it is an insert, since that's how
+-- they look to anyone following the catalogue. Changes to movies in the trash, and purging them, aren't reported at all.
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    recorded movie_events;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'insert') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'delete') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'insert') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND NEW.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'update') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'DELETE' AND OLD.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (OLD.id, OLD.version, 'delete') RETURNING * INTO recorded;
+    ELSE
+        RETURN NULL;
+    END IF;
+
+    PERFORM pg_notify('movie_events', json_build_object(
+        'seq', recorded.seq,
+        'id', recorded.movie_id,
+        'version', recorded.version,
+        'operation', recorded.operation,
+        'time', recorded.created_at)::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Only updates that change the version are changes to the movie itself. Other updates, like a new review changing the
+-- rating totals, leave it alone.
+DROP TRIGGER IF EXISTS movies_record_event ON movies;
+CREATE TRIGGER movies_record_event AFTER INSERT OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION record_movie_event();
+
+DROP TRIGGER IF EXISTS movies_record_update_event ON movies;
+CREATE TRIGGER movies_record_update_event AFTER UPDATE ON movies
+    FOR EACH ROW WHEN (OLD.version IS DISTINCT FROM NEW.version) EXECUTE FUNCTION record_movie_event();
@@ -0,0 +1,41 @@
+DROP TRIGGER IF EXISTS movies_record_event ON movies;
+DROP TRIGGER IF EXISTS movies_record_update_event ON movies;
+
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    recorded movie_events;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'insert') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'delete') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'insert') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND NEW.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (NEW.id, NEW.version, 'update') RETURNING * INTO recorded;
+    ELSIF TG_OP = 'DELETE' AND OLD.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation) VALUES (OLD.id, OLD.version, 'delete') RETURNING * INTO recorded;
+    ELSE
+        RETURN NULL;
+    END IF;
+
+    PERFORM pg_notify('movie_events', json_build_object(
+        'seq', recorded.seq,
+        'id', recorded.movie_id,
+        'version', recorded.version,
+        'operation', recorded.operation,
+        'time', recorded.created_at)::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Only updates that change the version are changes to the movie itself. Other updates, like a new review changing the
+-- rating totals, leave it alone.
+CREATE TRIGGER movies_record_event AFTER INSERT OR DELETE ON movies
+    FOR EACH ROW EXECUTE FUNCTION record_movie_event();
+
+CREATE TRIGGER movies_record_update_event AFTER UPDATE ON movies
+    FOR EACH ROW WHEN (OLD.version IS DISTINCT FROM NEW.version) EXECUTE FUNCTION record_movie_event();
+
+ALTER TABLE movie_events DROP COLUMN IF EXISTS genres;
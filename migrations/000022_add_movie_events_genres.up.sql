@@ -0,0 +1,62 @@
+-- Each event records the movie's genres as they were when it was committed, so that subscribers can filter the feed on genre
+-- without looking the movie up again, and get the same answer however much later they read the event.
+ALTER TABLE movie_events ADD COLUMN IF NOT EXISTS genres text[] NOT NULL DEFAULT '{}';
+
+-- A movie's genres are written after its row, in the same transaction, so the events are recorded when the transaction
+-- commits (see the deferred triggers below) rather than straight after the row changes. By then the genres are final. A movie
+-- that's deleted for good has lost its genres by then too, but only movies in the trash are deleted for good, and they
+-- aren't reported.
+CREATE OR REPLACE FUNCTION record_movie_event() RETURNS trigger AS $$
+DECLARE
+    recorded movie_events;
+    movie bigint;
+    genre_names text[];
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        movie := OLD.id;
+    ELSE
+        movie := NEW.id;
+    END IF;
+
+    SELECT COALESCE(array_agg(g.name ORDER BY g.name), '{}') INTO genre_names
+    FROM movie_genres mg
+    INNER JOIN genres g ON g.id = mg.genre_id
+    WHERE mg.movie_id = movie;
+
+    IF TG_OP = 'INSERT' THEN
+        INSERT INTO movie_events (movie_id, version, operation, genres) VALUES (NEW.id, NEW.version, 'insert', genre_names) RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation, genres) VALUES (NEW.id, NEW.version, 'delete', genre_names) RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation, genres) VALUES (NEW.id, NEW.version, 'insert', genre_names) RETURNING * INTO recorded;
+    ELSIF TG_OP = 'UPDATE' AND NEW.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation, genres) VALUES (NEW.id, NEW.version, 'update', genre_names) RETURNING * INTO recorded;
+    ELSIF TG_OP = 'DELETE' AND OLD.deleted_at IS NULL THEN
+        INSERT INTO movie_events (movie_id, version, operation, genres) VALUES (OLD.id, OLD.version, 'delete', genre_names) RETURNING * INTO recorded;
+    ELSE
+        RETURN NULL;
+    END IF;
+
+    PERFORM pg_notify('movie_events', json_build_object(
+        'seq', recorded.seq,
+        'id', recorded.movie_id,
+        'version', recorded.version,
+        'operation', recorded.operation,
+        'genres', recorded.genres,
+        'time', recorded.created_at)::text);
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS movies_record_event ON movies;
+CREATE CONSTRAINT TRIGGER movies_record_event AFTER INSERT OR DELETE ON movies
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION record_movie_event();
+
+-- Only updates that change the version are changes to the movie itself. Other updates, like a new review changing the
+-- rating totals, leave it alone.
+DROP TRIGGER IF EXISTS movies_record_update_event ON movies;
+CREATE CONSTRAINT TRIGGER movies_record_update_event AFTER UPDATE ON movies
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW WHEN (OLD.version IS DISTINCT FROM NEW.version) EXECUTE FUNCTION record_movie_event();